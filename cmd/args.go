@@ -1,51 +1,108 @@
 package cmd
 
 import (
-	"github.com/spf13/cobra"
 	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var NonMptfArgs []string
 
+// argsSeparator ends mapotf's own args, everything after it is passed to Terraform verbatim.
+const argsSeparator = "--"
+
+// FilterArgs splits the command line into mapotf's args and Terraform's args. mapotf's flags are looked up in the flag
+// sets of the root command and the sub command, other args are passed to Terraform, so are all args after `--`.
+// Terraform's `-chdir` is mapped onto `--tf-dir`.
 func FilterArgs(inputArgs []string) ([]string, []string) {
 	var mptfArgs, nonMptfArgs []string
 	mptfArgs = append(mptfArgs, inputArgs[0])
 	inputArgs = inputArgs[1:]
-	var subCommands = make(map[string]struct{})
-	for _, cmd := range append([]*cobra.Command{
-		NewTransformCmd(),
-		NewDebugCmd(),
-		NewResetCmd(),
-		NewClearBackupCmd(),
-	}, terraformCommands...) {
-		subCommands[cmd.Use] = struct{}{}
-	}
-	mptfVarFlags := map[string]struct{}{
-		"--tf-dir":        {},
-		"--mptf-dir":      {},
-		"--mptf-var":      {},
-		"--mptf-var-file": {},
-		"--help":          {},
-	}
-	mptfShortHands := map[string]struct{}{
-		"-r": {},
-		"-h": {},
-	}
+	var subCommand *cobra.Command
 	for i := 0; i < len(inputArgs); i++ {
 		arg := inputArgs[i]
-		if _, isSubCommand := subCommands[arg]; isSubCommand {
-			mptfArgs = append(mptfArgs, arg)
-		} else if _, isMptfVarFlag := mptfVarFlags[arg]; isMptfVarFlag {
-			mptfArgs = append(mptfArgs, arg)
-			if i != len(inputArgs)-1 && !strings.HasPrefix(inputArgs[i+1], "-") {
-				mptfArgs = append(mptfArgs, inputArgs[i+1])
+		if arg == argsSeparator {
+			nonMptfArgs = append(nonMptfArgs, inputArgs[i+1:]...)
+			break
+		}
+		if subCommand == nil && !strings.HasPrefix(arg, "-") {
+			if c := lookupSubCommand(arg); c != nil {
+				subCommand = c
+				mptfArgs = append(mptfArgs, arg)
+				continue
+			}
+		}
+		flagName, value, withValue := strings.Cut(arg, "=")
+		if flagName == "-chdir" {
+			if !withValue && i != len(inputArgs)-1 {
+				value = inputArgs[i+1]
 				i++
 			}
-		} else if _, isMptfShorthand := mptfShortHands[arg]; isMptfShorthand {
-			mptfArgs = append(mptfArgs, arg)
-		} else {
+			mptfArgs = append(mptfArgs, "--tf-dir", value)
+			continue
+		}
+		flag, normalized := lookupMptfFlag(subCommand, flagName)
+		if flag == nil {
 			nonMptfArgs = append(nonMptfArgs, arg)
+			continue
+		}
+		if withValue {
+			mptfArgs = append(mptfArgs, normalized+"="+value)
+			continue
+		}
+		mptfArgs = append(mptfArgs, normalized)
+		// Switches like `--dry-run` take no value, the value of other flags is the next arg, even if it starts with `-`.
+		if flag.NoOptDefVal == "" && i != len(inputArgs)-1 {
+			mptfArgs = append(mptfArgs, inputArgs[i+1])
+			i++
 		}
 	}
 	return mptfArgs, nonMptfArgs
 }
+
+func lookupSubCommand(name string) *cobra.Command {
+	for _, c := range rootCmd.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// lookupMptfFlag returns mapotf's flag of the given name, along with the name that cobra accepts. Long flags need double
+// dashes, but mapotf's own sub commands also accept them with a single dash like Terraform does, e.g.
+// `plan-transform -out`. Wrapped Terraform commands don't, so Terraform's flags like `fmt -recursive` are kept for
+// Terraform.
+func lookupMptfFlag(subCommand *cobra.Command, name string) (*pflag.Flag, string) {
+	var flagSets []*pflag.FlagSet
+	if subCommand != nil {
+		subCommand.InitDefaultHelpFlag()
+		flagSets = append(flagSets, subCommand.Flags())
+	}
+	rootCmd.InitDefaultHelpFlag()
+	flagSets = append(flagSets, rootCmd.PersistentFlags(), rootCmd.Flags())
+	isTerraformCommand := subCommand != nil && isTerraformCommand(subCommand)
+	for _, fs := range flagSets {
+		switch {
+		case strings.HasPrefix(name, "--"):
+			if f := fs.Lookup(strings.TrimPrefix(name, "--")); f != nil {
+				return f, name
+			}
+		case strings.HasPrefix(name, "-") && len(name) == 2:
+			if f := fs.ShorthandLookup(strings.TrimPrefix(name, "-")); f != nil {
+				return f, name
+			}
+		case strings.HasPrefix(name, "-") && !isTerraformCommand:
+			if f := fs.Lookup(strings.TrimPrefix(name, "-")); f != nil {
+				return f, "-" + name
+			}
+		}
+	}
+	return nil, ""
+}
+
+func isTerraformCommand(c *cobra.Command) bool {
+	_, ok := terraformCmds[c.Name()]
+	return ok
+}
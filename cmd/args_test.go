@@ -32,6 +32,60 @@ func TestFilterArgs(t *testing.T) {
 			expectedMptf:    []string{"mapotf", "apply", "--mptf-var", "mptfa=b", "--mptf-var-file", "mptf.var"},
 			expectedNonMptf: []string{"-var", "a=b", "-var-file=\"terraform.tfvars\"", "-var", "c=d"},
 		},
+		{
+			name:            "Test with mptf flag assigned by equal sign",
+			inputArgs:       []string{"mapotf", "plan", "--format=all", "--tf-dir", "/testTerraform", "-var-file=terraform.tfvars"},
+			expectedMptf:    []string{"mapotf", "plan", "--format=all", "--tf-dir", "/testTerraform"},
+			expectedNonMptf: []string{"-var-file=terraform.tfvars"},
+		},
+		{
+			name:            "Test with mptf switch",
+			inputArgs:       []string{"mapotf", "transform", "--dry-run", "--mptf-dir", "/testMptf"},
+			expectedMptf:    []string{"mapotf", "transform", "--dry-run", "--mptf-dir", "/testMptf"},
+			expectedNonMptf: nil,
+		},
+		{
+			name:            "Test with plan-transform single dash out",
+			inputArgs:       []string{"mapotf", "plan-transform", "-out", "plan.mptfplan", "--mptf-dir", "/testMptf"},
+			expectedMptf:    []string{"mapotf", "plan-transform", "--out", "plan.mptfplan", "--mptf-dir", "/testMptf"},
+			expectedNonMptf: nil,
+		},
+		{
+			name:            "Test with terraform plan out",
+			inputArgs:       []string{"mapotf", "plan", "-out", "tfplan", "--mptf-dir", "/testMptf"},
+			expectedMptf:    []string{"mapotf", "plan", "--mptf-dir", "/testMptf"},
+			expectedNonMptf: []string{"-out", "tfplan"},
+		},
+		{
+			name:            "Test with separator",
+			inputArgs:       []string{"mapotf", "apply", "--mptf-dir", "/testMptf", "--", "-var", "a=b", "--tf-dir", "/passed/to/terraform"},
+			expectedMptf:    []string{"mapotf", "apply", "--mptf-dir", "/testMptf"},
+			expectedNonMptf: []string{"-var", "a=b", "--tf-dir", "/passed/to/terraform"},
+		},
+		{
+			name:            "Test with mptf flag value starts with dash",
+			inputArgs:       []string{"mapotf", "transform", "--mptf-var", "-name=x", "--mptf-dir", "/testMptf"},
+			expectedMptf:    []string{"mapotf", "transform", "--mptf-var", "-name=x", "--mptf-dir", "/testMptf"},
+			expectedNonMptf: nil,
+		},
+		{
+			name:            "Test with chdir",
+			inputArgs:       []string{"mapotf", "-chdir=/testTerraform", "plan", "-var-file=x.tfvars"},
+			expectedMptf:    []string{"mapotf", "--tf-dir", "/testTerraform", "plan"},
+			expectedNonMptf: []string{"-var-file=x.tfvars"},
+		},
+		{
+			name:            "Test with terraform flag named like mptf flag",
+			inputArgs:       []string{"mapotf", "fmt", "-recursive", "-check"},
+			expectedMptf:    []string{"mapotf", "fmt"},
+			expectedNonMptf: []string{"-recursive", "-check"},
+		},
+		{
+			name:            "Test with mapotf command single dash long flag",
+			inputArgs:       []string{"mapotf", "transform", "-dry-run", "-r", "--tf-binary", "tofu"},
+			expectedMptf:    []string{"mapotf", "transform", "--dry-run", "-r", "--tf-binary", "tofu"},
+			expectedNonMptf: nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// checkTransform computes the changes the same way as dry run, it lists the files that would be changed along with
+// the transforms that would change them, and returns an error so the process exits with non-zero code.
+func checkTransform(recursive bool, output string, out io.Writer, ctx context.Context) error {
+	changes, journal, err := dryRun(recursive, ctx)
+	if err != nil {
+		return err
+	}
+	if output == outputJson {
+		if err = printJsonPlan(out, changes, journal); err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("%d file(s) would be changed by transforms", len(changes))
+		}
+		return nil
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(out, "All transforms have been applied, no file would be changed.")
+		return nil
+	}
+	for _, c := range changes {
+		action := "changed"
+		if c.newFile {
+			action = "created"
+		}
+		_, _ = fmt.Fprintf(out, "%s would be %s by:\n", displayPath(c.path), action)
+		for _, w := range c.writers {
+			_, _ = fmt.Fprintf(out, "  %s\n", w.String())
+		}
+	}
+	return fmt.Errorf("%d file(s) would be changed by transforms", len(changes))
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTransform(t *testing.T) {
+	cases := []struct {
+		desc          string
+		terraformCode string
+		expectError   bool
+		expectedOut   string
+	}{
+		{
+			desc: "transforms have not been applied",
+			terraformCode: `resource "fake_resource" this {
+}
+`,
+			expectError: true,
+			expectedOut: `main.tf would be changed by:
+  transform.update_in_place.this(/testData/main.mptf.hcl)
+`,
+		},
+		{
+			desc: "transforms have been applied",
+			terraformCode: `resource "fake_resource" this {
+  tags = {}
+}
+`,
+			expectError: false,
+			expectedOut: "All transforms have been applied, no file would be changed.\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			_ = afero.WriteFile(fs, "/testData/main.mptf.hcl", []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`), 0644)
+			_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(c.terraformCode), 0644)
+			stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+				tfDir:    "/testTerraform",
+				mptfDirs: []string{"/testData"},
+				format:   "touched",
+			}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+				return dir, nil
+			})
+			defer stub.Reset()
+
+			out := new(bytes.Buffer)
+			err := checkTransform(false, outputText, out, context.Background())
+			if c.expectError {
+				assert.NotNil(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, c.expectedOut, out.String())
+			content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+			require.NoError(t, err)
+			assert.Equal(t, c.terraformCode, string(content))
+		})
+	}
+}
+
+func TestCheckTransform_RelativeTfDir(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "mptf"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "tf"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "mptf", "main.mptf.hcl"), []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "tf", "main.tf"), []byte("resource \"fake_resource\" this {\n}\n"), 0644))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmp))
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	stub := gostub.Stub(&filesystem.Fs, afero.NewOsFs()).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "tf",
+		mptfDirs: []string{filepath.Join(tmp, "mptf")},
+		format:   "touched",
+	})
+	defer stub.Reset()
+
+	out := new(bytes.Buffer)
+	err = checkTransform(false, outputText, out, context.Background())
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "main.tf would be changed by:\n")
+}
@@ -15,20 +15,27 @@ func NewClearBackupCmd() *cobra.Command {
 		FParseErrWhitelist: cobra.FParseErrWhitelist{
 			UnknownFlags: true,
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: withTfDirLock(func(cmd *cobra.Command, args []string) error {
 			return cleanBackup()
-		},
+		}),
 	}
 }
 
 func cleanBackup() error {
-	moduleRefs, err := pkg.ModuleRefs(cf.tfDir)
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	if err = backup.ClearBackup(root); err != nil {
+		return err
+	}
+	moduleRefs, err := pkg.ModuleRefsSkippingBroken(cf.tfDir)
 	if err != nil {
 		return err
 	}
 	for _, tfDir := range moduleRefs {
 		d := tfDir
-		err = backup.ClearBackup(d.AbsDir)
+		err = backup.ClearLegacyBackup(d.AbsDir)
 		if err != nil {
 			return err
 		}
@@ -56,6 +56,7 @@ func replFunc(tfDir, mptfDir *string) func(c *cobra.Command, args []string) erro
 		if err != nil {
 			return err
 		}
+		pkg.TerraformVersion = terraformVersion()
 		cfg, err := pkg.NewMetaProgrammingTFConfig(mod, nil, hclBlocks, varFlags, c.Context())
 		if err != nil {
 			return err
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const maxStatBarWidth = 40
+
+func NewDiffCmd() *cobra.Command {
+	recursive := false
+	stat := false
+	nameOnly := false
+
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show changes made by transforms by comparing Terraform files with their backups, mapotf diff [-r] [--stat|--name-only] --tf-dir []",
+		FParseErrWhitelist: cobra.FParseErrWhitelist{
+			UnknownFlags: true,
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return diff(recursive, stat, nameOnly, cmd.OutOrStdout())
+		},
+	}
+
+	diffCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Show changes in all modules or not, default to the root module only.")
+	diffCmd.Flags().BoolVar(&stat, "stat", false, "Show the number of inserted and deleted lines per file instead of the diff.")
+	diffCmd.Flags().BoolVar(&nameOnly, "name-only", false, "Show the names of changed files only.")
+	return diffCmd
+}
+
+func diff(recursive, stat, nameOnly bool, out io.Writer) error {
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
+	if err != nil {
+		return err
+	}
+	changes, err := backupChanges(moduleRefs)
+	if err != nil {
+		return err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	switch {
+	case len(changes) == 0:
+		_, _ = fmt.Fprintln(out, "No changes.")
+	case nameOnly:
+		for _, c := range changes {
+			_, _ = fmt.Fprintln(out, displayPath(c.path))
+		}
+	case stat:
+		printDiffStat(out, changes)
+	default:
+		printUnifiedDiff(out, changes, useColor(out))
+	}
+	return nil
+}
+
+// backupChanges compares the Terraform files in the given modules with their content before the oldest active backup
+// run, or with the legacy backups next to them, files created by transforms are compared with empty content.
+func backupChanges(moduleRefs []*pkg.TerraformModuleRef) ([]fileChange, error) {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return nil, err
+	}
+	originals, err := backup.OriginalFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]struct{})
+	for _, ref := range moduleRefs {
+		dirs[ref.AbsDir] = struct{}{}
+	}
+	merged := make(map[string]backup.OriginalFile)
+	for _, original := range originals {
+		merged[original.Path] = original
+	}
+	// Legacy backups have been taken before any run of the backup store, they're the oldest content.
+	for _, ref := range moduleRefs {
+		legacyOriginals, err := backup.LegacyOriginalFiles(ref.AbsDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, original := range legacyOriginals {
+			merged[original.Path] = original
+		}
+	}
+	var r []fileChange
+	for _, original := range merged {
+		if _, ok := dirs[filepath.Dir(original.Path)]; !ok {
+			continue
+		}
+		after, err := afero.ReadFile(filesystem.Fs, original.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot read %s: %+v", original.Path, err)
+		}
+		// Created files might have been removed by `mapotf reset --transform`.
+		if original.Created && os.IsNotExist(err) {
+			continue
+		}
+		if !original.Created && bytes.Equal(original.Content, after) {
+			continue
+		}
+		r = append(r, fileChange{
+			path:    original.Path,
+			before:  original.Content,
+			after:   after,
+			newFile: original.Created,
+		})
+	}
+	return r, nil
+}
+
+func diffLineCounts(c fileChange) (insertions, deletions int) {
+	matcher := difflib.NewMatcher(splitLines(c.before), splitLines(c.after))
+	for _, op := range matcher.GetOpCodes() {
+		switch op.Tag {
+		case 'r':
+			deletions += op.I2 - op.I1
+			insertions += op.J2 - op.J1
+		case 'd':
+			deletions += op.I2 - op.I1
+		case 'i':
+			insertions += op.J2 - op.J1
+		}
+	}
+	return
+}
+
+func printDiffStat(out io.Writer, changes []fileChange) {
+	type stat struct {
+		path       string
+		insertions int
+		deletions  int
+	}
+	var stats []stat
+	nameWidth, maxChanges, totalInsertions, totalDeletions := 0, 0, 0, 0
+	for _, c := range changes {
+		s := stat{path: displayPath(c.path)}
+		s.insertions, s.deletions = diffLineCounts(c)
+		nameWidth = max(nameWidth, len(s.path))
+		maxChanges = max(maxChanges, s.insertions+s.deletions)
+		totalInsertions += s.insertions
+		totalDeletions += s.deletions
+		stats = append(stats, s)
+	}
+	countWidth := len(fmt.Sprint(maxChanges))
+	for _, s := range stats {
+		plus, minus := s.insertions, s.deletions
+		if maxChanges > maxStatBarWidth {
+			plus = plus * maxStatBarWidth / maxChanges
+			minus = minus * maxStatBarWidth / maxChanges
+		}
+		_, _ = fmt.Fprintf(out, " %-*s | %*d %s%s\n", nameWidth, s.path, countWidth, s.insertions+s.deletions, strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	_, _ = fmt.Fprintf(out, " %d file(s) changed, %d insertion(s)(+), %d deletion(s)(-)\n", len(stats), totalInsertions, totalDeletions)
+}
+
+func init() {
+	rootCmd.AddCommand(NewDiffCmd())
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubDiffFs(t *testing.T) func() {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(`resource "fake_resource" this {
+}
+`), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/untouched.tf", []byte(`variable "a" {}
+`), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&cf, &commonFlags{
+		tfDir: "/testTerraform",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	run, err := backup.NewRun("/testTerraform")
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder("/testTerraform"))
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(`resource "fake_resource" this {
+  tags = {}
+}
+`), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/locals.tf", []byte(`locals {
+  a = 1
+}
+`), 0644)
+	require.NoError(t, run.Save())
+	return stub.Reset
+}
+
+func TestDiff(t *testing.T) {
+	defer stubDiffFs(t)()
+	out := new(bytes.Buffer)
+	require.NoError(t, diff(false, false, false, out))
+	assert.Equal(t, `--- /dev/null
++++ b/locals.tf
+@@ -0,0 +1,3 @@
++locals {
++  a = 1
++}
+--- a/main.tf
++++ b/main.tf
+@@ -1,2 +1,3 @@
+ resource "fake_resource" this {
++  tags = {}
+ }
+`, out.String())
+}
+
+func TestDiff_NameOnly(t *testing.T) {
+	defer stubDiffFs(t)()
+	out := new(bytes.Buffer)
+	require.NoError(t, diff(false, false, true, out))
+	assert.Equal(t, "locals.tf\nmain.tf\n", out.String())
+}
+
+func TestDiff_Stat(t *testing.T) {
+	defer stubDiffFs(t)()
+	out := new(bytes.Buffer)
+	require.NoError(t, diff(false, true, false, out))
+	assert.Equal(t, ` locals.tf | 3 +++
+ main.tf   | 1 +
+ 2 file(s) changed, 4 insertion(s)(+), 0 deletion(s)(-)
+`, out.String())
+}
+
+func TestDiff_LegacyBackups(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte("resource \"fake_resource\" this {\n  tags = {}\n}\n"), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf"+backup.BackupExtension, []byte("resource \"fake_resource\" this {\n}\n"), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/locals.tf", []byte("locals {\n  a = 1\n}\n"), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/locals.tf"+backup.NewFileExtension, []byte{}, 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&cf, &commonFlags{
+		tfDir: "/testTerraform",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	defer stub.Reset()
+
+	out := new(bytes.Buffer)
+	require.NoError(t, diff(false, false, false, out))
+	assert.Equal(t, `--- /dev/null
++++ b/locals.tf
+@@ -0,0 +1,3 @@
++locals {
++  a = 1
++}
+--- a/main.tf
++++ b/main.tf
+@@ -1,2 +1,3 @@
+ resource "fake_resource" this {
++  tags = {}
+ }
+`, out.String())
+}
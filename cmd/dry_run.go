@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/mattn/go-isatty"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+)
+
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+	colorBold  = "\033[1m"
+)
+
+type fileChange struct {
+	path      string
+	moduleKey string
+	before    []byte
+	after     []byte
+	newFile   bool
+	mode      os.FileMode
+	writers   []terraform.Writer
+}
+
+func dryRunTransform(recursive bool, output string, out io.Writer, ctx context.Context) error {
+	changes, journal, err := dryRun(recursive, ctx)
+	if err != nil {
+		return err
+	}
+	if output == outputJson {
+		return printJsonPlan(out, changes, journal)
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(out, "No changes.")
+		return nil
+	}
+	printUnifiedDiff(out, changes, useColor(out))
+	return nil
+}
+
+// dryRun applies the transforms against an in-memory layer on top of a read-only view of the file system, then
+// compares the layer with the original files. Nothing is written to disk, backup files included.
+func dryRun(recursive bool, ctx context.Context) ([]fileChange, *terraform.WriteJournal, error) {
+	base := filesystem.Fs
+	filesystem.Fs = afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(base), afero.NewMemMapFs())
+	defer func() {
+		filesystem.Fs = base
+	}()
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
+	if err != nil {
+		return nil, nil, err
+	}
+	journal := terraform.NewWriteJournal()
+	if err = applyTransforms(moduleRefs, journal, io.Discard, ctx); err != nil {
+		return nil, nil, err
+	}
+	changes, err := changedFiles(moduleRefs, base, filesystem.Fs, journal)
+	return changes, journal, err
+}
+
+func changedFiles(moduleRefs []*pkg.TerraformModuleRef, before, after afero.Fs, journal *terraform.WriteJournal) ([]fileChange, error) {
+	var r []fileChange
+	for _, ref := range moduleRefs {
+		files, err := afero.Glob(after, filepath.Join(ref.AbsDir, "*.tf"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list terraform files in %s: %+v", ref.AbsDir, err)
+		}
+		for _, f := range files {
+			afterContent, err := afero.ReadFile(after, f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %+v", f, err)
+			}
+			info, err := after.Stat(f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot stat %s: %+v", f, err)
+			}
+			exist, err := afero.Exists(before, f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot check %s: %+v", f, err)
+			}
+			var beforeContent []byte
+			if exist {
+				if beforeContent, err = afero.ReadFile(before, f); err != nil {
+					return nil, fmt.Errorf("cannot read %s: %+v", f, err)
+				}
+			}
+			if exist && bytes.Equal(beforeContent, afterContent) {
+				continue
+			}
+			r = append(r, fileChange{
+				path:      f,
+				moduleKey: ref.Key,
+				before:    beforeContent,
+				after:     afterContent,
+				newFile:   !exist,
+				mode:      info.Mode(),
+				writers:   journal.FileWriters(f),
+			})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].path < r[j].path
+	})
+	return r, nil
+}
+
+func unifiedDiff(c fileChange) string {
+	return unifiedDiffWithPath(c, displayPath(c.path))
+}
+
+func unifiedDiffWithPath(c fileChange, path string) string {
+	from := "a/" + path
+	if c.newFile {
+		from = "/dev/null"
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(c.before),
+		B:        splitLines(c.after),
+		FromFile: from,
+		ToFile:   "b/" + path,
+		Context:  3,
+	})
+	return diff
+}
+
+// splitLines keeps the line breaks, unlike `difflib.SplitLines` it marks a last line without line break the way git does.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+func printUnifiedDiff(out io.Writer, changes []fileChange, color bool) {
+	for _, c := range changes {
+		diff := unifiedDiff(c)
+		if !color {
+			_, _ = fmt.Fprint(out, diff)
+			continue
+		}
+		for _, line := range strings.SplitAfter(diff, "\n") {
+			_, _ = fmt.Fprint(out, colorizeDiffLine(line))
+		}
+	}
+}
+
+func colorizeDiffLine(line string) string {
+	if line == "" {
+		return line
+	}
+	content, newline := strings.CutSuffix(line, "\n")
+	suffix := ""
+	if newline {
+		suffix = "\n"
+	}
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		return colorBold + content + colorReset + suffix
+	case strings.HasPrefix(line, "@@"):
+		return colorCyan + content + colorReset + suffix
+	case strings.HasPrefix(line, "+"):
+		return colorGreen + content + colorReset + suffix
+	case strings.HasPrefix(line, "-"):
+		return colorRed + content + colorReset + suffix
+	}
+	return line
+}
+
+// useColor follows https://no-color.org, and only colors the output when it's a terminal.
+func useColor(out io.Writer) bool {
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor {
+		return false
+	}
+	f, ok := out.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+func displayPath(path string) string {
+	tfDir, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	absPath, err := pkg.AbsDir(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	if rel, err := filepath.Rel(tfDir, absPath); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunShouldNotWriteAnyFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testData/main.mptf.hcl", []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "new_block" locals {
+  new_block_type = "locals"
+  filename       = "locals.tf"
+  asraw {
+    a = 1
+  }
+}
+`), 0644)
+	terraformCode := `resource "fake_resource" this {
+}
+`
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(terraformCode), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "/testTerraform",
+		mptfDirs: []string{"/testData"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	defer stub.Reset()
+
+	out := new(bytes.Buffer)
+	err := dryRunTransform(false, outputText, out, context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, `--- /dev/null
++++ b/locals.tf
+@@ -0,0 +1,4 @@
++locals {
++  a = 1
++}
++
+--- a/main.tf
++++ b/main.tf
+@@ -1,2 +1,3 @@
+ resource "fake_resource" this {
++  tags = {}
+ }
+`, out.String())
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, terraformCode, string(content))
+	files, err := afero.ReadDir(fs, "/testTerraform")
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/spf13/afero"
+)
+
+// emitPatch writes the changes that transforms would make as a git-format patch instead of changing the working tree, paths
+// in the patch are relative to the root of the git repository, so it can be applied by `git apply` at the root.
+func emitPatch(recursive bool, patchPath string, out io.Writer, ctx context.Context) error {
+	changes, _, err := dryRun(recursive, ctx)
+	if err != nil {
+		return err
+	}
+	root, err := pkg.GitRoot(cf.tfDir)
+	if err != nil {
+		return fmt.Errorf("cannot find git repository for %s: %+v", cf.tfDir, err)
+	}
+	var patch strings.Builder
+	for _, c := range changes {
+		path, err := gitRelativePath(root, c.path)
+		if err != nil {
+			return err
+		}
+		patch.WriteString(gitDiff(c, path))
+	}
+	if err = afero.WriteFile(filesystem.Fs, patchPath, []byte(patch.String()), 0644); err != nil {
+		return fmt.Errorf("cannot write patch file %s: %+v", patchPath, err)
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintf(out, "No changes, empty patch saved to %s.\n", patchPath)
+		return nil
+	}
+	_, _ = fmt.Fprintf(out, "%d file(s) changed, patch saved to %s, apply it by `git apply` at %s.\n", len(changes), patchPath, root)
+	return nil
+}
+
+func gitRelativePath(root, path string) (string, error) {
+	absPath, err := pkg.AbsDir(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot get absolute path for %s: %+v", path, err)
+	}
+	rel, err := filepath.Rel(root, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not in git repository %s", path, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func gitDiff(c fileChange, path string) string {
+	header := fmt.Sprintf("diff --git a/%s b/%s\n", path, path)
+	if c.newFile {
+		header += fmt.Sprintf("new file mode %s\n", gitFileMode(c.mode))
+	}
+	return header + unifiedDiffWithPath(c, path)
+}
+
+// gitFileMode returns the mode of a regular file in git, which only tells whether the file is executable.
+func gitFileMode(mode os.FileMode) string {
+	if mode&0111 != 0 {
+		return "100755"
+	}
+	return "100644"
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/go-git/go-git/v5"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitPatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testData/main.mptf.hcl", []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "new_block" locals {
+  new_block_type = "locals"
+  filename       = "locals.tf"
+  asraw {
+    a = 1
+  }
+}
+`), 0644)
+	terraformCode := `resource "fake_resource" this {
+}
+`
+	_ = afero.WriteFile(fs, "/repo/testTerraform/main.tf", []byte(terraformCode), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "/repo/testTerraform",
+		mptfDirs: []string{"/testData"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	}).Stub(&pkg.GitRoot, func(string) (string, error) {
+		return "/repo", nil
+	})
+	defer stub.Reset()
+
+	err := emitPatch(false, "/out.patch", new(bytes.Buffer), context.Background())
+	require.NoError(t, err)
+
+	patch, err := afero.ReadFile(fs, "/out.patch")
+	require.NoError(t, err)
+	assert.Equal(t, `diff --git a/testTerraform/locals.tf b/testTerraform/locals.tf
+new file mode 100644
+--- /dev/null
++++ b/testTerraform/locals.tf
+@@ -0,0 +1,4 @@
++locals {
++  a = 1
++}
++
+diff --git a/testTerraform/main.tf b/testTerraform/main.tf
+--- a/testTerraform/main.tf
++++ b/testTerraform/main.tf
+@@ -1,2 +1,3 @@
+ resource "fake_resource" this {
++  tags = {}
+ }
+`, string(patch))
+	content, err := afero.ReadFile(fs, "/repo/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, terraformCode, string(content))
+	files, err := afero.ReadDir(fs, "/repo/testTerraform")
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestEmitPatch_NoNewlineAtEndOfFileShouldApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repoDir := t.TempDir()
+	tfDir := filepath.Join(repoDir, "tf")
+	mptfDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(tfDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tfDir, "main.tf"), []byte("resource \"fake_resource\" this {\n}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mptfDir, "main.mptf.hcl"), []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`), 0644))
+	_, err := git.PlainInit(repoDir, false)
+	require.NoError(t, err)
+	stub := gostub.Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    tfDir,
+		mptfDirs: []string{mptfDir},
+		format:   "touched",
+	})
+	defer stub.Reset()
+	patchPath := filepath.Join(t.TempDir(), "out.patch")
+
+	err = emitPatch(false, patchPath, new(bytes.Buffer), context.Background())
+	require.NoError(t, err)
+
+	patch, err := os.ReadFile(patchPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), "\\ No newline at end of file\n")
+	cmd := exec.Command("git", "apply", "--check", patchPath)
+	cmd.Dir = repoDir
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+}
+
+func TestGitDiff_NewFileMode(t *testing.T) {
+	c := fileChange{
+		after:   []byte("#!/bin/sh\n"),
+		newFile: true,
+		mode:    0755,
+	}
+	assert.Contains(t, gitDiff(c, "run.sh"), "new file mode 100755\n")
+	c.mode = 0644
+	assert.Contains(t, gitDiff(c, "main.tf"), "new file mode 100644\n")
+}
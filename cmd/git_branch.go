@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const defaultGitCommitMessage = "Apply mapotf transforms"
+
+// transformToGitBranch applies the transforms, then commits the changed and created Terraform files on a new local branch.
+// Backup files are left in the working tree but never committed.
+func transformToGitBranch(recursive bool, branch, message string, out io.Writer, ctx context.Context) error {
+	root, err := pkg.GitRoot(cf.tfDir)
+	if err != nil {
+		return fmt.Errorf("cannot find git repository for %s: %+v", cf.tfDir, err)
+	}
+	repo, err := git.PlainOpen(root)
+	if err != nil {
+		return fmt.Errorf("cannot open git repository %s: %+v", root, err)
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err = branchRef.Validate(); err != nil {
+		return fmt.Errorf("invalid branch name %s: %+v", branch, err)
+	}
+	if _, err = repo.Reference(branchRef, false); err == nil {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("cannot get HEAD of git repository %s: %+v", root, err)
+	}
+	author, err := gitAuthor(repo)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("cannot get worktree of %s: %+v", root, err)
+	}
+	if err = ensureNothingStaged(worktree); err != nil {
+		return err
+	}
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotTerraformFiles(moduleRefs)
+	if err != nil {
+		return err
+	}
+	run, err := backupModules(cf.tfDir, moduleRefs)
+	if err != nil {
+		return err
+	}
+	journal := terraform.NewWriteJournal()
+	if err = applyTransforms(moduleRefs, journal, out, ctx); err != nil {
+		_ = run.Discard()
+		return err
+	}
+	if err = saveRun(run, journal); err != nil {
+		return err
+	}
+	changes, err := changedFiles(moduleRefs, snapshot, filesystem.Fs, journal)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(out, "No changes, no branch has been created.")
+		return nil
+	}
+	if err = worktree.Checkout(&git.CheckoutOptions{
+		Branch: branchRef,
+		Create: true,
+		Keep:   true,
+	}); err != nil {
+		return fmt.Errorf("cannot create branch %s: %+v", branch, err)
+	}
+	hash, err := commitChanges(worktree, root, changes, gitCommitMessage(message, journal), author)
+	if err != nil {
+		abandonGitBranch(repo, worktree, head, branchRef)
+		return fmt.Errorf("cannot commit changes to branch %s, the transformed files are left in the working tree: %+v", branch, err)
+	}
+	_, _ = fmt.Fprintf(out, "%d file(s) committed to branch %s as %s.\n", len(changes), branch, hash.String())
+	return nil
+}
+
+// ensureNothingStaged refuses to commit if the index already contains changes, since they'd be committed along with the
+// transformed files.
+func ensureNothingStaged(worktree *git.Worktree) error {
+	status, err := worktree.Status()
+	if err != nil {
+		return fmt.Errorf("cannot get git status: %+v", err)
+	}
+	var staged []string
+	for path, s := range status {
+		if s.Staging != git.Unmodified && s.Staging != git.Untracked {
+			staged = append(staged, path)
+		}
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+	sort.Strings(staged)
+	return fmt.Errorf("following files have been staged, please commit or unstage them first:\n  %s", strings.Join(staged, "\n  "))
+}
+
+// gitAuthor returns the identity configured by `git config`, so a missing one fails before any file is transformed.
+func gitAuthor(repo *git.Repository) (*object.Signature, error) {
+	cfg, err := repo.ConfigScoped(config.GlobalScope)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read git config: %+v", err)
+	}
+	if cfg.User.Name == "" || cfg.User.Email == "" {
+		return nil, fmt.Errorf("git author identity is unknown, set it by `git config user.name` and `git config user.email` first")
+	}
+	return &object.Signature{
+		Name:  cfg.User.Name,
+		Email: cfg.User.Email,
+	}, nil
+}
+
+func commitChanges(worktree *git.Worktree, root string, changes []fileChange, message string, author *object.Signature) (plumbing.Hash, error) {
+	for _, c := range changes {
+		path, err := gitRelativePath(root, c.path)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err = worktree.Add(path); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("cannot stage %s: %+v", path, err)
+		}
+	}
+	author.When = time.Now()
+	return worktree.Commit(message, &git.CommitOptions{
+		Author: author,
+	})
+}
+
+// abandonGitBranch unstages the files, switches back to the original HEAD and deletes the new branch.
+func abandonGitBranch(repo *git.Repository, worktree *git.Worktree, head *plumbing.Reference, branchRef plumbing.ReferenceName) {
+	_ = worktree.Reset(&git.ResetOptions{
+		Commit: head.Hash(),
+		Mode:   git.MixedReset,
+	})
+	checkout := &git.CheckoutOptions{
+		Hash: head.Hash(),
+		Keep: true,
+	}
+	if head.Name().IsBranch() {
+		checkout = &git.CheckoutOptions{
+			Branch: head.Name(),
+			Keep:   true,
+		}
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return
+	}
+	_ = repo.Storer.RemoveReference(branchRef)
+}
+
+func gitCommitMessage(message string, journal *terraform.WriteJournal) string {
+	if message == "" {
+		message = defaultGitCommitMessage
+	}
+	var transforms []string
+	seen := make(map[terraform.Writer]struct{})
+	for _, e := range journal.Entries() {
+		if _, ok := seen[e.Writer]; ok {
+			continue
+		}
+		seen[e.Writer] = struct{}{}
+		transforms = append(transforms, fmt.Sprintf("- %s (%s)", e.Writer.Address, displayPath(e.Writer.Source)))
+	}
+	sort.Strings(transforms)
+	var sb strings.Builder
+	sb.WriteString(message)
+	sb.WriteString("\n\nTransforms:\n")
+	sb.WriteString(strings.Join(transforms, "\n"))
+	sb.WriteString("\n\nMptf sources:\n")
+	for _, dir := range cf.mptfDirs {
+		sb.WriteString(fmt.Sprintf("- %s\n", dir))
+	}
+	return sb.String()
+}
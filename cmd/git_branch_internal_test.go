@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/prashantv/gostub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newGitBranchTestRepo(t *testing.T, identity bool) (repo *git.Repository, tfDir, mptfDir string) {
+	// Keep the global git config of the machine out of the tests.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repoDir := t.TempDir()
+	tfDir = filepath.Join(repoDir, "tf")
+	mptfDir = t.TempDir()
+	require.NoError(t, os.MkdirAll(tfDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tfDir, "main.tf"), []byte(`resource "fake_resource" this {
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mptfDir, "main.mptf.hcl"), []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "new_block" locals {
+  new_block_type = "locals"
+  filename       = "locals.tf"
+  asraw {
+    a = 1
+  }
+}
+`), 0644))
+	repo, err := git.PlainInit(repoDir, false)
+	require.NoError(t, err)
+	if identity {
+		cfg, err := repo.Config()
+		require.NoError(t, err)
+		cfg.User.Name = "tester"
+		cfg.User.Email = "tester@example.com"
+		require.NoError(t, repo.SetConfig(cfg))
+	}
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("tf/main.tf")
+	require.NoError(t, err)
+	_, err = worktree.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "tester", Email: "tester@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return repo, tfDir, mptfDir
+}
+
+func TestTransformToGitBranch(t *testing.T) {
+	repo, tfDir, mptfDir := newGitBranchTestRepo(t, true)
+	stub := gostub.Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    tfDir,
+		mptfDirs: []string{mptfDir},
+		format:   "touched",
+	})
+	defer stub.Reset()
+
+	err := transformToGitBranch(false, "mptf/test", "Add tags", new(bytes.Buffer), context.Background())
+	require.NoError(t, err)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("mptf/test"), head.Name())
+	commit, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Contains(t, commit.Message, "Add tags")
+	assert.Contains(t, commit.Message, "transform.update_in_place.this")
+	assert.Contains(t, commit.Message, "transform.new_block.locals")
+	assert.Contains(t, commit.Message, mptfDir)
+	tree, err := commit.Tree()
+	require.NoError(t, err)
+	var files []string
+	require.NoError(t, tree.Files().ForEach(func(f *object.File) error {
+		files = append(files, f.Name)
+		return nil
+	}))
+	sort.Strings(files)
+	assert.Equal(t, []string{"tf/locals.tf", "tf/main.tf"}, files)
+	main, err := tree.File("tf/main.tf")
+	require.NoError(t, err)
+	content, err := main.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" this {
+  tags = {}
+}
+`, content)
+}
+
+func TestTransformToGitBranch_WithoutIdentityShouldChangeNothing(t *testing.T) {
+	repo, tfDir, mptfDir := newGitBranchTestRepo(t, false)
+	stub := gostub.Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    tfDir,
+		mptfDirs: []string{mptfDir},
+		format:   "touched",
+	})
+	defer stub.Reset()
+
+	err := transformToGitBranch(false, "mptf/test", "Add tags", new(bytes.Buffer), context.Background())
+	require.Error(t, err)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.Master, head.Name())
+	_, err = repo.Reference(plumbing.NewBranchReferenceName("mptf/test"), false)
+	assert.Error(t, err)
+	content, err := os.ReadFile(filepath.Join(tfDir, "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, "resource \"fake_resource\" this {\n}\n", string(content))
+}
+
+func TestAbandonGitBranch(t *testing.T) {
+	repo, tfDir, _ := newGitBranchTestRepo(t, true)
+	head, err := repo.Head()
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	branchRef := plumbing.NewBranchReferenceName("mptf/test")
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{
+		Branch: branchRef,
+		Create: true,
+		Keep:   true,
+	}))
+	require.NoError(t, os.WriteFile(filepath.Join(tfDir, "main.tf"), []byte("locals {}\n"), 0644))
+	_, err = worktree.Add("tf/main.tf")
+	require.NoError(t, err)
+
+	abandonGitBranch(repo, worktree, head, branchRef)
+
+	current, err := repo.Head()
+	require.NoError(t, err)
+	assert.Equal(t, head.Name(), current.Name())
+	_, err = repo.Reference(branchRef, false)
+	assert.Error(t, err)
+	status, err := worktree.Status()
+	require.NoError(t, err)
+	assert.Equal(t, git.Unmodified, status.File("tf/main.tf").Staging)
+	assert.Equal(t, git.Modified, status.File("tf/main.tf").Worktree)
+}
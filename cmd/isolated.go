@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// isolatedSkippedEntries are not copied from the root module into the isolated workspace.
+var isolatedSkippedEntries = map[string]struct{}{
+	".git":                     {},
+	".mapotf":                  {},
+	".terraform":               {},
+	"terraform.tfstate":        {},
+	"terraform.tfstate.backup": {},
+	"terraform.tfstate.d":      {},
+}
+
+// isolatedLinkedStateFiles are linked from the isolated workspace to the root module, so Terraform reads and writes the
+// same local state. They're linked even if they don't exist yet, so the state created by the first apply is kept.
+var isolatedLinkedStateFiles = []string{"terraform.tfstate", "terraform.tfstate.backup"}
+
+// isolatedPathFlags are Terraform's flags whose values are paths, relative paths are rewritten against the Terraform
+// directory so files written by Terraform are not lost with the isolated workspace.
+var isolatedPathFlags = map[string]struct{}{
+	"-out":                 {},
+	"-state":               {},
+	"-state-out":           {},
+	"-backup":              {},
+	"-var-file":            {},
+	"-generate-config-out": {},
+}
+
+// runIsolated applies the transforms to a copy of the root module and its installed modules, then runs Terraform in the
+// copy, so the source tree is never changed. The copy is removed once Terraform exits, unless it's kept for inspection.
+func runIsolated(tfCmd string, recursive, keep, keepOnFailure bool, cmd *cobra.Command, args []string) (err error) {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	workspace := filepath.Join(os.TempDir(), "mapotf-"+uuid.NewString())
+	defer func() {
+		if keep || (keepOnFailure && err != nil) {
+			_, _ = fmt.Fprintf(os.Stderr, "Isolated workspace is kept for inspection at %s, your source tree has not been changed.\n", workspace)
+			return
+		}
+		_ = filesystem.Fs.RemoveAll(workspace)
+	}()
+	if err = newIsolatedWorkspace(root, workspace); err != nil {
+		return err
+	}
+	tfDir := cf.tfDir
+	cf.tfDir = workspace
+	defer func() {
+		cf.tfDir = tfDir
+	}()
+	if _, err = transform(recursive, cmd.Context()); err != nil {
+		return err
+	}
+	if err = cmd.Context().Err(); err != nil {
+		return err
+	}
+	tfArgs := NonMptfArgs
+	NonMptfArgs = isolatedTerraformArgs(root, tfArgs)
+	defer func() {
+		NonMptfArgs = tfArgs
+	}()
+	return wrapTerraformCommand(tfCmd)(cmd, args)
+}
+
+// isolatedTerraformArgs returns Terraform's args with relative paths of path flags, e.g. `-out=tfplan`, made absolute
+// against root.
+func isolatedTerraformArgs(root string, args []string) []string {
+	r := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, withValue := strings.Cut(arg, "=")
+		name = "-" + strings.TrimLeft(name, "-")
+		if _, ok := isolatedPathFlags[name]; !ok || !strings.HasPrefix(arg, "-") {
+			r = append(r, arg)
+			continue
+		}
+		if withValue {
+			r = append(r, name+"="+isolatedPath(root, value))
+			continue
+		}
+		r = append(r, arg)
+		if i+1 < len(args) {
+			r = append(r, isolatedPath(root, args[i+1]))
+			i++
+		}
+	}
+	return r
+}
+
+func isolatedPath(root, path string) string {
+	// `-backup=-` disables the backup.
+	if path == "" || path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// newIsolatedWorkspace copies the root module and `.terraform/modules` into the workspace, other entries in `.terraform`
+// and the local state files are linked, so Terraform uses the same providers, backend config and state.
+func newIsolatedWorkspace(root, workspace string) error {
+	linker, ok := filesystem.Fs.(afero.Linker)
+	if !ok {
+		return fmt.Errorf("isolated mode requires symlinks, which are not supported by the file system")
+	}
+	if err := filesystem.CopyDir(root, workspace, func(rel string) bool {
+		_, skip := isolatedSkippedEntries[rel]
+		return skip
+	}); err != nil {
+		return err
+	}
+	for _, name := range isolatedLinkedStateFiles {
+		if err := linker.SymlinkIfPossible(filepath.Join(root, name), filepath.Join(workspace, name)); err != nil {
+			return fmt.Errorf("cannot link %s into isolated workspace: %+v", name, err)
+		}
+	}
+	// Workspaces' states are linked only if they exist, Terraform would take the dangling link as a file otherwise.
+	workspacesDir := filepath.Join(root, "terraform.tfstate.d")
+	if exist, err := afero.DirExists(filesystem.Fs, workspacesDir); err != nil {
+		return fmt.Errorf("cannot check %s: %+v", workspacesDir, err)
+	} else if exist {
+		if err = linker.SymlinkIfPossible(workspacesDir, filepath.Join(workspace, "terraform.tfstate.d")); err != nil {
+			return fmt.Errorf("cannot link %s into isolated workspace: %+v", workspacesDir, err)
+		}
+	}
+	dataDir := filepath.Join(root, ".terraform")
+	exist, err := afero.DirExists(filesystem.Fs, dataDir)
+	if err != nil || !exist {
+		return err
+	}
+	if err = filesystem.Fs.MkdirAll(filepath.Join(workspace, ".terraform"), 0755); err != nil {
+		return fmt.Errorf("cannot create .terraform in isolated workspace: %+v", err)
+	}
+	entries, err := afero.ReadDir(filesystem.Fs, dataDir)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %+v", dataDir, err)
+	}
+	for _, e := range entries {
+		src, dst := filepath.Join(dataDir, e.Name()), filepath.Join(workspace, ".terraform", e.Name())
+		if e.Name() == "modules" {
+			if err = filesystem.CopyDir(src, dst, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = linker.SymlinkIfPossible(src, dst); err != nil {
+			return fmt.Errorf("cannot link %s into isolated workspace: %+v", src, err)
+		}
+	}
+	return rewriteModulesJson(root, workspace)
+}
+
+// rewriteModulesJson points every module in the workspace's `modules.json` to its copy with an absolute path. Local
+// modules outside the root module, e.g. `../modules/x`, are copied into the workspace too.
+func rewriteModulesJson(root, workspace string) error {
+	manifest, err := pkg.LoadModuleManifest(workspace)
+	if err != nil || manifest == nil {
+		return err
+	}
+	for i, m := range manifest.Modules {
+		dir := m.Get("Dir")
+		abs := filepath.FromSlash(dir)
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(root, abs)
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil {
+			return fmt.Errorf("cannot resolve module %s: %+v", dir, err)
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			m["Dir"] = filepath.Join(workspace, rel)
+			continue
+		}
+		external := filepath.Join(workspace, ".terraform", "modules", ".mapotf-external", fmt.Sprintf("%d-%s", i, filepath.Base(abs)))
+		if err = filesystem.CopyDir(abs, external, nil); err != nil {
+			return err
+		}
+		m["Dir"] = external
+	}
+	return manifest.Save()
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIsolatedWorkspace(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	external := filepath.Join(base, "external")
+	workspace := filepath.Join(t.TempDir(), "workspace")
+	files := map[string]string{
+		filepath.Join(root, "main.tf"):                                   `module "vnet" {}`,
+		filepath.Join(root, "modules", "local", "main.tf"):               `resource "fake_resource" local {}`,
+		filepath.Join(root, ".terraform", "modules", "vnet", "main.tf"):  `resource "fake_resource" vnet {}`,
+		filepath.Join(root, ".terraform", "providers", "fake", "binary"): "fake",
+		filepath.Join(root, ".terraform", "terraform.tfstate"):           `{"backend": {}}`,
+		filepath.Join(root, ".mapotf", "lock"):                           "{}",
+		filepath.Join(external, "main.tf"):                               `resource "fake_resource" external {}`,
+		filepath.Join(root, ".terraform", "modules", "modules.json"): `{"Modules":[
+{"Key":"","Source":"","Dir":"."},
+{"Key":"vnet","Source":"registry.terraform.io/Azure/vnet/azurerm","Version":"1.0.0","Dir":".terraform/modules/vnet"},
+{"Key":"local","Source":"./modules/local","Dir":"modules/local"},
+{"Key":"external","Source":"../external","Dir":"../external"}]}`,
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	require.NoError(t, newIsolatedWorkspace(root, workspace))
+
+	_, err := os.Stat(filepath.Join(workspace, ".mapotf"))
+	assert.True(t, os.IsNotExist(err))
+	link, err := os.Readlink(filepath.Join(workspace, "terraform.tfstate"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "terraform.tfstate"), link)
+	link, err = os.Readlink(filepath.Join(workspace, ".terraform", "providers"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, ".terraform", "providers"), link)
+	manifestJson, err := os.ReadFile(filepath.Join(workspace, ".terraform", "modules", "modules.json"))
+	require.NoError(t, err)
+	var manifest struct {
+		Modules []map[string]string `json:"Modules"`
+	}
+	require.NoError(t, json.Unmarshal(manifestJson, &manifest))
+	require.Len(t, manifest.Modules, 4)
+	assert.Equal(t, workspace, manifest.Modules[0]["Dir"])
+	assert.Equal(t, filepath.Join(workspace, ".terraform", "modules", "vnet"), manifest.Modules[1]["Dir"])
+	assert.Equal(t, "1.0.0", manifest.Modules[1]["Version"])
+	assert.Equal(t, filepath.Join(workspace, "modules", "local"), manifest.Modules[2]["Dir"])
+	for _, m := range manifest.Modules {
+		content, err := os.ReadFile(filepath.Join(m["Dir"], "main.tf"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, content)
+	}
+
+	// Changes in the workspace never reach the source tree, the state does.
+	require.NoError(t, os.WriteFile(filepath.Join(manifest.Modules[1]["Dir"], "main.tf"), []byte("changed"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(manifest.Modules[3]["Dir"], "main.tf"), []byte("changed"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "terraform.tfstate"), []byte("{}"), 0644))
+	content, err := os.ReadFile(filepath.Join(root, ".terraform", "modules", "vnet", "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" vnet {}`, string(content))
+	content, err = os.ReadFile(filepath.Join(external, "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" external {}`, string(content))
+	content, err = os.ReadFile(filepath.Join(root, "terraform.tfstate"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
+
+func TestIsolatedTerraformArgs(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "cfg")
+	args := []string{"-out=tfplan", "-state", "states/dev.tfstate", "--var-file=dev.tfvars", "-backup=-", "-lock=false", "-var", "file=x.txt", "-state-out=" + filepath.Join(root, "abs.tfstate")}
+
+	assert.Equal(t, []string{
+		"-out=" + filepath.Join(root, "tfplan"),
+		"-state", filepath.Join(root, "states", "dev.tfstate"),
+		"-var-file=" + filepath.Join(root, "dev.tfvars"),
+		"-backup=-",
+		"-lock=false",
+		"-var", "file=x.txt",
+		"-state-out=" + filepath.Join(root, "abs.tfstate"),
+	}, isolatedTerraformArgs(root, args))
+}
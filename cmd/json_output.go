@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/spf13/afero"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+// jsonPlanFormatVersion must be bumped when a field is removed or its meaning changes, adding fields is compatible.
+const jsonPlanFormatVersion = "1.0"
+
+type jsonPlan struct {
+	FormatVersion string          `json:"format_version"`
+	Transforms    []jsonTransform `json:"transforms"`
+	Files         []jsonFile      `json:"files"`
+	Warnings      []string        `json:"warnings"`
+}
+
+type jsonTransform struct {
+	Address      string       `json:"address"`
+	Type         string       `json:"type"`
+	Source       string       `json:"source"`
+	ModuleKey    string       `json:"module_key"`
+	TargetBlocks []string     `json:"target_blocks"`
+	Changes      []jsonChange `json:"changes"`
+}
+
+type jsonChange struct {
+	File   string `json:"file"`
+	Block  string `json:"block"`
+	Path   string `json:"path,omitempty"`
+	Action string `json:"action"`
+}
+
+type jsonFile struct {
+	Path         string   `json:"path"`
+	ModuleKey    string   `json:"module_key"`
+	NewFile      bool     `json:"new_file"`
+	BeforeSha256 *string  `json:"before_sha256"`
+	AfterSha256  string   `json:"after_sha256"`
+	Transforms   []string `json:"transforms"`
+}
+
+// transformWithJsonOutput applies the transforms like `transform` does, but prints the json plan instead of the progress.
+func transformWithJsonOutput(recursive bool, out io.Writer, ctx context.Context) error {
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotTerraformFiles(moduleRefs)
+	if err != nil {
+		return err
+	}
+	run, err := backupModules(cf.tfDir, moduleRefs)
+	if err != nil {
+		return err
+	}
+	journal := terraform.NewWriteJournal()
+	if err = applyTransforms(moduleRefs, journal, io.Discard, ctx); err != nil {
+		_ = run.Discard()
+		return err
+	}
+	if err = saveRun(run, journal); err != nil {
+		return err
+	}
+	changes, err := changedFiles(moduleRefs, snapshot, filesystem.Fs, journal)
+	if err != nil {
+		return err
+	}
+	return printJsonPlan(out, changes, journal)
+}
+
+// snapshotTerraformFiles copies the terraform files of the given modules into memory, so they can be compared with the
+// transformed files later.
+func snapshotTerraformFiles(moduleRefs []*pkg.TerraformModuleRef) (afero.Fs, error) {
+	snapshot := afero.NewMemMapFs()
+	for _, ref := range moduleRefs {
+		files, err := afero.Glob(filesystem.Fs, filepath.Join(ref.AbsDir, "*.tf"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list terraform files in %s: %+v", ref.AbsDir, err)
+		}
+		for _, f := range files {
+			content, err := afero.ReadFile(filesystem.Fs, f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %+v", f, err)
+			}
+			if err = afero.WriteFile(snapshot, f, content, 0644); err != nil {
+				return nil, fmt.Errorf("cannot snapshot %s: %+v", f, err)
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+func printJsonPlan(out io.Writer, changes []fileChange, journal *terraform.WriteJournal) error {
+	content, err := json.MarshalIndent(newJsonPlan(changes, journal), "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal plan: %+v", err)
+	}
+	_, _ = fmt.Fprintln(out, string(content))
+	return nil
+}
+
+func newJsonPlan(changes []fileChange, journal *terraform.WriteJournal) jsonPlan {
+	plan := jsonPlan{
+		FormatVersion: jsonPlanFormatVersion,
+		Transforms:    []jsonTransform{},
+		Files:         []jsonFile{},
+		Warnings:      []string{},
+	}
+	entries := journal.Entries()
+	for _, applied := range journal.AppliedTransforms() {
+		t := jsonTransform{
+			Address:      applied.Writer.Address,
+			Type:         applied.Writer.Type,
+			Source:       displayPath(applied.Writer.Source),
+			ModuleKey:    applied.ModuleKey,
+			TargetBlocks: []string{},
+			Changes:      []jsonChange{},
+		}
+		targets := make(map[string]struct{})
+		for _, e := range entries {
+			if e.Writer != applied.Writer || e.ModuleKey != applied.ModuleKey {
+				continue
+			}
+			if _, ok := targets[e.Block]; !ok {
+				targets[e.Block] = struct{}{}
+				t.TargetBlocks = append(t.TargetBlocks, e.Block)
+			}
+			t.Changes = append(t.Changes, jsonChange{
+				File:   displayPath(e.File),
+				Block:  e.Block,
+				Path:   e.Path,
+				Action: string(e.Action),
+			})
+		}
+		if len(t.Changes) == 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s made no change to module %q", applied.Writer.String(), applied.ModuleKey))
+		}
+		plan.Transforms = append(plan.Transforms, t)
+	}
+	for _, c := range changes {
+		f := jsonFile{
+			Path:        displayPath(c.path),
+			ModuleKey:   c.moduleKey,
+			NewFile:     c.newFile,
+			AfterSha256: sha256Hex(c.after),
+			Transforms:  []string{},
+		}
+		if !c.newFile {
+			before := sha256Hex(c.before)
+			f.BeforeSha256 = &before
+		}
+		for _, w := range c.writers {
+			f.Transforms = append(f.Transforms, w.Address)
+		}
+		plan.Files = append(plan.Files, f)
+	}
+	return plan
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
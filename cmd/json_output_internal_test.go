@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformWithJsonOutput(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testData/main.mptf.hcl", []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "remove_nested_block" nothing {
+  target_block_address = "resource.fake_resource.this"
+  paths                = ["not_exist"]
+}
+`), 0644)
+	terraformCode := `resource "fake_resource" this {
+}
+`
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(terraformCode), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform", "--output", "json"}).Stub(&cf, &commonFlags{
+		tfDir:    "/testTerraform",
+		mptfDirs: []string{"/testData"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	defer stub.Reset()
+
+	out := new(bytes.Buffer)
+	err := transformWithJsonOutput(false, out, context.Background())
+	require.NoError(t, err)
+
+	var plan jsonPlan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &plan))
+	assert.Equal(t, jsonPlanFormatVersion, plan.FormatVersion)
+	require.Len(t, plan.Transforms, 2)
+	assert.Equal(t, []string{`transform.remove_nested_block.nothing(/testData/main.mptf.hcl) made no change to module ""`}, plan.Warnings)
+	var transform jsonTransform
+	for _, tr := range plan.Transforms {
+		if tr.Address == "transform.update_in_place.this" {
+			transform = tr
+		}
+	}
+	assert.Equal(t, "transform.update_in_place.this", transform.Address)
+	assert.Equal(t, "update_in_place", transform.Type)
+	assert.Equal(t, []string{"resource.fake_resource.this"}, transform.TargetBlocks)
+	assert.Equal(t, []jsonChange{
+		{
+			File:   "main.tf",
+			Block:  "resource.fake_resource.this",
+			Path:   "tags",
+			Action: "set_attribute",
+		},
+	}, transform.Changes)
+	require.Len(t, plan.Files, 1)
+	file := plan.Files[0]
+	assert.Equal(t, "main.tf", file.Path)
+	assert.False(t, file.NewFile)
+	require.NotNil(t, file.BeforeSha256)
+	assert.Equal(t, sha256Hex([]byte(terraformCode)), *file.BeforeSha256)
+	after, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex(after), file.AfterSha256)
+	assert.Equal(t, []string{"transform.update_in_place.this"}, file.Transforms)
+}
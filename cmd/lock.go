@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	"github.com/spf13/cobra"
+)
+
+// lockTfDir takes the lock of the Terraform directory, so other mapotf processes cannot change the same files until the
+// returned func is called.
+func lockTfDir() (func(), error) {
+	return lockDir(cf.tfDir)
+}
+
+func lockDir(tfDir string) (func(), error) {
+	root, err := pkg.AbsDir(tfDir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := backup.AcquireLock(root, commandLine())
+	if err != nil {
+		var lockedErr *backup.LockedError
+		if errors.As(err, &lockedErr) {
+			return nil, fmt.Errorf("%s\nif that process is no longer running, run `mapotf force-unlock --tf-dir %s` to remove the lock", err.Error(), tfDir)
+		}
+		return nil, err
+	}
+	return func() {
+		if err := lock.Release(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}, nil
+}
+
+// commandLine returns the command line of this process, including the arguments passed to Terraform.
+func commandLine() string {
+	return strings.Join(append(append([]string{}, os.Args...), NonMptfArgs...), " ")
+}
+
+// withTfDirLock wraps a command so it runs with the lock of the Terraform directory.
+func withTfDirLock(run func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		unlock, err := lockTfDir()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return run(cmd, args)
+	}
+}
+
+// wrapForceUnlock shares `force-unlock` with Terraform: without a lock id it removes mapotf's own lock, with a lock id
+// it's passed to `terraform force-unlock` as before.
+func wrapForceUnlock(tfForceUnlock func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		for _, arg := range append(append([]string{}, args...), NonMptfArgs...) {
+			if !strings.HasPrefix(arg, "-") {
+				return tfForceUnlock(cmd, args)
+			}
+		}
+		return forceUnlock(cmd.OutOrStdout())
+	}
+}
+
+func forceUnlock(out io.Writer) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	info, err := backup.ForceUnlock(root)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		_, _ = fmt.Fprintf(out, "%s is not locked.\n", cf.tfDir)
+		return nil
+	}
+	_, _ = fmt.Fprintf(out, "Lock removed, it was held by %s.\n", info.String())
+	return nil
+}
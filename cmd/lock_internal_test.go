@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockTfDir_SecondLockShouldSuggestForceUnlock(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, afero.NewMemMapFs()).Stub(&cf, &commonFlags{
+		tfDir: "/testTerraform",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	defer stub.Reset()
+
+	unlock, err := lockTfDir()
+	require.NoError(t, err)
+	_, err = lockTfDir()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mapotf force-unlock --tf-dir /testTerraform")
+
+	unlock()
+	unlock, err = lockTfDir()
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	require.NoError(t, forceUnlock(out))
+	assert.Contains(t, out.String(), "Lock removed, it was held by PID")
+	out.Reset()
+	require.NoError(t, forceUnlock(out))
+	assert.Equal(t, "/testTerraform is not locked.\n", out.String())
+	unlock()
+}
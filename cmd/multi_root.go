@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/spf13/afero"
+)
+
+const defaultParallelism = 4
+
+// rootResult is the result of the transforms on one root module in multi-root mode.
+type rootResult struct {
+	dir          string
+	changedFiles int
+	err          error
+}
+
+func (r rootResult) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("  failed  %s: %s", r.dir, strings.TrimSpace(r.err.Error()))
+	}
+	if r.changedFiles == 0 {
+		return fmt.Sprintf("  ok      %s: no changes", r.dir)
+	}
+	if r.changedFiles == 1 {
+		return fmt.Sprintf("  ok      %s: 1 file changed", r.dir)
+	}
+	return fmt.Sprintf("  ok      %s: %d files changed", r.dir, r.changedFiles)
+}
+
+// multiRootMode tells whether `transform` runs on the root modules of a roots file or a `--tf-dir` glob.
+func multiRootMode(rootsFile string) bool {
+	return rootsFile != "" || hasGlobMeta(cf.tfDir)
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// resolveRoots returns the sorted root module dirs matched by the tfDir glob or listed in the roots file.
+func resolveRoots(tfDir, rootsFile string) ([]string, error) {
+	patterns := []string{tfDir}
+	if rootsFile != "" {
+		content, err := afero.ReadFile(filesystem.Fs, rootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read roots file %s: %+v", rootsFile, err)
+		}
+		patterns = nil
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(filepath.Dir(rootsFile), line)
+			}
+			patterns = append(patterns, line)
+		}
+	}
+	seen := make(map[string]struct{})
+	var roots []string
+	for _, pattern := range patterns {
+		dirs, err := matchRoots(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			dir = filepath.Clean(dir)
+			if _, ok := seen[dir]; ok {
+				continue
+			}
+			seen[dir] = struct{}{}
+			roots = append(roots, dir)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no root module found in %s", strings.Join(patterns, ", "))
+	}
+	sort.Strings(roots)
+	return roots, nil
+}
+
+func matchRoots(pattern string) ([]string, error) {
+	if !hasGlobMeta(pattern) {
+		isDir, err := afero.IsDir(filesystem.Fs, pattern)
+		if err != nil || !isDir {
+			return nil, fmt.Errorf("root module %s is not a dir", pattern)
+		}
+		return []string{pattern}, nil
+	}
+	matches, err := afero.Glob(filesystem.Fs, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %s: %+v", pattern, err)
+	}
+	var dirs []string
+	for _, m := range matches {
+		if isDir, err := afero.IsDir(filesystem.Fs, m); err != nil || !isDir {
+			continue
+		}
+		tfFiles, err := afero.Glob(filesystem.Fs, filepath.Join(m, "*.tf"))
+		if err != nil || len(tfFiles) == 0 {
+			continue
+		}
+		dirs = append(dirs, m)
+	}
+	return dirs, nil
+}
+
+// transformRoots applies the transforms to every root module by at most parallelism workers, then prints a report.
+func transformRoots(roots []string, recursive bool, parallelism int, out io.Writer, ctx context.Context) error {
+	if parallelism < 1 {
+		return fmt.Errorf("invalid parallelism %d, must be at least 1", parallelism)
+	}
+	mptfDirs, err := cf.MptfDirs(ctx)
+	if err != nil {
+		return err
+	}
+	defer disposeMptfDirs(mptfDirs)
+	pkg.TerraformVersion = terraformVersion()
+
+	results := make([]rootResult, len(roots))
+	workers := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, root string) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			results[i] = transformRoot(root, recursive, mptfDirs, ctx)
+		}(i, root)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	_, _ = fmt.Fprintf(out, "Transforms applied to %d of %d root modules:\n", len(results)-failed, len(results))
+	for _, r := range results {
+		_, _ = fmt.Fprintln(out, r.String())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d root modules failed", failed, len(results))
+	}
+	return nil
+}
+
+func transformRoot(tfDir string, recursive bool, mptfDirs []localizedMptfDir, ctx context.Context) rootResult {
+	r := rootResult{dir: tfDir}
+	unlock, err := lockDir(tfDir)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer unlock()
+	root, err := pkg.AbsDir(tfDir)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	// There's no terminal to prompt for each root module, interrupted sessions are left to `mapotf reset`.
+	s, err := backup.InterruptedSession(root)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	if s != nil {
+		r.err = fmt.Errorf("a previous mapotf session (PID %d: %s) was interrupted before restoring the transformed files, run `mapotf reset --tf-dir %s` first", s.Pid, s.Command, tfDir)
+		return r
+	}
+	moduleRefs, err := transformModuleRefs(tfDir, recursive)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	run, err := backupModules(tfDir, moduleRefs)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	journal := terraform.NewWriteJournal()
+	if err = applyLocalizedMptfDirs(tfDir, mptfDirs, moduleRefs, journal, io.Discard, ctx); err != nil {
+		_ = run.Discard()
+		r.err = err
+		return r
+	}
+	r.err = saveRun(run, journal)
+	changed := make(map[string]struct{})
+	for _, c := range journal.FileChanges() {
+		changed[c.File] = struct{}{}
+	}
+	r.changedFiles = len(changed)
+	return r
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubMultiRootFs(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/mptf/main.mptf.hcl": `
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`,
+		"/repo/envs/dev/main.tf":    "resource \"fake_resource\" this {\n}\n",
+		"/repo/envs/prod/main.tf":   "resource \"fake_resource\" this {\n}\n",
+		"/repo/envs/test/main.tf":   "resource \"fake_resource\" this {\n",
+		"/repo/envs/docs/readme.md": "# envs\n",
+		"/repo/roots.txt":           "# all environments\nenvs/*\n\nenvs/dev\n",
+	}
+	for path, content := range files {
+		require.NoError(t, afero.WriteFile(fs, path, []byte(content), 0644))
+	}
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "/repo/envs/*",
+		mptfDirs: []string{"/mptf"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	t.Cleanup(stub.Reset)
+	return fs
+}
+
+func TestResolveRoots(t *testing.T) {
+	stubMultiRootFs(t)
+	expected := []string{"/repo/envs/dev", "/repo/envs/prod", "/repo/envs/test"}
+
+	roots, err := resolveRoots("/repo/envs/*", "")
+	require.NoError(t, err)
+	assert.Equal(t, expected, roots)
+
+	roots, err = resolveRoots("/repo/envs/*", "/repo/roots.txt")
+	require.NoError(t, err)
+	assert.Equal(t, expected, roots)
+
+	_, err = resolveRoots("/repo/stacks/*", "")
+	require.Error(t, err)
+	_, err = resolveRoots("/repo/envs/staging", "")
+	require.Error(t, err)
+}
+
+func TestTransformRoots_ShouldReportEveryRoot(t *testing.T) {
+	fs := stubMultiRootFs(t)
+
+	out := new(bytes.Buffer)
+	err := transformRoots([]string{"/repo/envs/dev", "/repo/envs/prod", "/repo/envs/test"}, false, 2, out, context.Background())
+	require.EqualError(t, err, "1 of 3 root modules failed")
+	assert.Contains(t, out.String(), "Transforms applied to 2 of 3 root modules:\n  ok      /repo/envs/dev: 1 file changed\n  ok      /repo/envs/prod: 1 file changed\n  failed  /repo/envs/test: ")
+	for _, env := range []string{"dev", "prod"} {
+		content, err := afero.ReadFile(fs, "/repo/envs/"+env+"/main.tf")
+		require.NoError(t, err)
+		assert.Equal(t, "resource \"fake_resource\" this {\n  tags = {}\n}\n", string(content))
+	}
+}
+
+func TestTransformRoots_RecursiveShouldResolveModulesAgainstRoot(t *testing.T) {
+	repo := t.TempDir()
+	mptfDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(mptfDir, "main.mptf.hcl"), []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`), 0644))
+	var roots []string
+	for _, env := range []string{"dev", "prod"} {
+		root := filepath.Join(repo, "envs", env)
+		roots = append(roots, root)
+		files := map[string]string{
+			"main.tf":                         "module \"net\" {\n  source = \"./modules/net\"\n}\n\nresource \"fake_resource\" this {\n}\n",
+			"modules/net/main.tf":             "resource \"fake_resource\" this {\n}\n",
+			".terraform/modules/modules.json": `{"Modules":[{"Key":"","Source":"","Dir":"."},{"Key":"net","Source":"./modules/net","Dir":"modules/net"}]}`,
+		}
+		for name, content := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		}
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	stub := gostub.Stub(&filesystem.Fs, afero.NewOsFs()).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    filepath.Join(repo, "envs", "*"),
+		mptfDirs: []string{mptfDir},
+		format:   "touched",
+	})
+	defer stub.Reset()
+
+	out := new(bytes.Buffer)
+	err = transformRoots(roots, true, 2, out, context.Background())
+	require.NoError(t, err, out.String())
+	for _, root := range roots {
+		content, err := os.ReadFile(filepath.Join(root, "modules", "net", "main.tf"))
+		require.NoError(t, err)
+		assert.Equal(t, "resource \"fake_resource\" this {\n  tags = {}\n}\n", string(content))
+	}
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// transformPlanFormatVersion must be bumped when the plan file's schema changes incompatibly.
+const transformPlanFormatVersion = "1.1"
+
+// transformPlan is the content of a saved plan file, paths are relative to the Terraform directory.
+type transformPlan struct {
+	FormatVersion string          `json:"format_version"`
+	Recursive     bool            `json:"recursive"`
+	MptfDirs      []string        `json:"mptf_dirs"`
+	Transforms    []jsonTransform `json:"transforms"`
+	Inputs        []planInput     `json:"inputs"`
+	Files         []planFile      `json:"files"`
+	Patches       []planPatch     `json:"patches"`
+	Warnings      []string        `json:"warnings"`
+}
+
+type planInput struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+}
+
+// planPatch is the change made by one transform to one file, so `mapotf reset --transform` can revert it.
+type planPatch struct {
+	Path      string `json:"path"`
+	Transform string `json:"transform"`
+	Source    string `json:"source"`
+	MptfDir   string `json:"mptf_dir"`
+	Created   bool   `json:"created"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+}
+
+type planFile struct {
+	jsonFile
+	Patch   string `json:"patch"`
+	Content string `json:"content"`
+}
+
+func NewPlanTransformCmd() *cobra.Command {
+	recursive := false
+	out := ""
+
+	planTransformCmd := &cobra.Command{
+		Use:   "plan-transform",
+		Short: "Save the changes that transforms would make to a plan file, mapotf plan-transform [-r] -out plan.mptfplan --tf-dir [] --mptf-dir [path to config files]",
+		FParseErrWhitelist: cobra.FParseErrWhitelist{
+			UnknownFlags: true,
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if out == "" {
+				return fmt.Errorf("the path of the plan file is required, please set it by `-out`")
+			}
+			return planTransform(recursive, out, cmd.OutOrStdout(), cmd.Context())
+		},
+	}
+
+	planTransformCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Plan transforms for all modules or not, default to the root module only.")
+	planTransformCmd.Flags().StringVar(&out, "out", "", "Path of the plan file, apply it later by `mapotf transform --plan`.")
+	return planTransformCmd
+}
+
+func planTransform(recursive bool, planPath string, out io.Writer, ctx context.Context) error {
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
+	if err != nil {
+		return err
+	}
+	inputs, err := planInputs(moduleRefs)
+	if err != nil {
+		return err
+	}
+	changes, journal, err := dryRun(recursive, ctx)
+	if err != nil {
+		return err
+	}
+	report := newJsonPlan(changes, journal)
+	plan := transformPlan{
+		FormatVersion: transformPlanFormatVersion,
+		Recursive:     recursive,
+		MptfDirs:      cf.mptfDirs,
+		Transforms:    report.Transforms,
+		Inputs:        inputs,
+		Files:         []planFile{},
+		Patches:       []planPatch{},
+		Warnings:      report.Warnings,
+	}
+	for i, c := range changes {
+		plan.Files = append(plan.Files, planFile{
+			jsonFile: report.Files[i],
+			Patch:    unifiedDiff(c),
+			Content:  string(c.after),
+		})
+	}
+	for _, c := range journal.FileChanges() {
+		plan.Patches = append(plan.Patches, planPatch{
+			Path:      displayPath(c.File),
+			Transform: c.Writer.Address,
+			Source:    c.Writer.Source,
+			MptfDir:   journal.MptfDir(c.Writer.Source),
+			Created:   c.Created,
+			Before:    string(c.Before),
+			After:     string(c.After),
+		})
+	}
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal plan: %+v", err)
+	}
+	if err = afero.WriteFile(filesystem.Fs, planPath, content, 0644); err != nil {
+		return fmt.Errorf("cannot write plan file %s: %+v", planPath, err)
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(out, "No changes.")
+	} else {
+		printUnifiedDiff(out, changes, useColor(out))
+	}
+	_, _ = fmt.Fprintf(out, "Plan saved to %s, run `mapotf transform --plan %s` to apply exactly these changes.\n", planPath, planPath)
+	return nil
+}
+
+// planInputs hashes all Terraform files that transforms read, a plan can only be applied if none of them has changed.
+func planInputs(moduleRefs []*pkg.TerraformModuleRef) ([]planInput, error) {
+	var r []planInput
+	for _, ref := range moduleRefs {
+		files, err := afero.Glob(filesystem.Fs, filepath.Join(ref.Dir, "*.tf"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list terraform files in %s: %+v", ref.Dir, err)
+		}
+		for _, f := range files {
+			content, err := afero.ReadFile(filesystem.Fs, f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %+v", f, err)
+			}
+			r = append(r, planInput{
+				Path:   displayPath(f),
+				Sha256: sha256Hex(content),
+			})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Path < r[j].Path
+	})
+	return r, nil
+}
+
+func readTransformPlan(planPath string) (*transformPlan, error) {
+	content, err := afero.ReadFile(filesystem.Fs, planPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan file %s: %+v", planPath, err)
+	}
+	var plan transformPlan
+	if err = json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("cannot parse plan file %s: %+v", planPath, err)
+	}
+	if plan.FormatVersion != transformPlanFormatVersion {
+		return nil, fmt.Errorf("unsupported plan file version %s, expected %s", plan.FormatVersion, transformPlanFormatVersion)
+	}
+	return &plan, nil
+}
+
+// applyTransformPlan writes the content recorded in the plan file without evaluating transforms again.
+func applyTransformPlan(planPath string, out io.Writer) error {
+	plan, err := readTransformPlan(planPath)
+	if err != nil {
+		return err
+	}
+	if err = verifyPlanInputs(plan); err != nil {
+		return err
+	}
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	run, err := backup.NewRun(root)
+	if err != nil {
+		return err
+	}
+	for _, f := range plan.Files {
+		if err = run.BackupFolder(filepath.Dir(planFilePath(f.Path))); err != nil {
+			_ = run.Discard()
+			return err
+		}
+	}
+	stage := terraform.NewStage()
+	for _, f := range plan.Files {
+		if err = stagePlanFile(stage, f); err != nil {
+			_ = run.Discard()
+			return err
+		}
+	}
+	if err = stage.Commit(); err != nil {
+		_ = run.Discard()
+		return err
+	}
+	for _, p := range plan.Patches {
+		path := filepath.FromSlash(p.Path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		run.AddPatch(path, backup.NewPatch(p.Transform, p.Source, p.MptfDir, p.Created, []byte(p.Before), []byte(p.After)))
+	}
+	if err = run.Save(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "Transforms applied successfully.")
+	return nil
+}
+
+func verifyPlanInputs(plan *transformPlan) error {
+	var changed []string
+	for _, input := range plan.Inputs {
+		content, err := afero.ReadFile(filesystem.Fs, planFilePath(input.Path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				changed = append(changed, input.Path)
+				continue
+			}
+			return fmt.Errorf("cannot read %s: %+v", input.Path, err)
+		}
+		if sha256Hex(content) != input.Sha256 {
+			changed = append(changed, input.Path)
+		}
+	}
+	for _, f := range plan.Files {
+		if !f.NewFile {
+			continue
+		}
+		exist, err := afero.Exists(filesystem.Fs, planFilePath(f.Path))
+		if err != nil {
+			return fmt.Errorf("cannot check %s: %+v", f.Path, err)
+		}
+		if exist {
+			changed = append(changed, f.Path)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("the plan is stale, following files have been changed since the plan was created, please create a new plan:\n  %s", strings.Join(changed, "\n  "))
+	}
+	return nil
+}
+
+func stagePlanFile(stage *terraform.Stage, f planFile) error {
+	path := planFilePath(f.Path)
+	mode := os.FileMode(0644)
+	if !f.NewFile {
+		info, err := filesystem.Fs.Stat(path)
+		if err != nil {
+			return fmt.Errorf("cannot stat %s: %+v", path, err)
+		}
+		mode = info.Mode().Perm()
+	}
+	return stage.Put(path, []byte(f.Content), mode)
+}
+
+func planFilePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cf.tfDir, filepath.FromSlash(path))
+}
+
+func init() {
+	rootCmd.AddCommand(NewPlanTransformCmd())
+}
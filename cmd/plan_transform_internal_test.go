@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubPlanTransformFs() (afero.Fs, func()) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testData/main.mptf.hcl", []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "new_block" locals {
+  new_block_type = "locals"
+  filename       = "locals.tf"
+  asraw {
+    a = 1
+  }
+}
+`), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(`resource "fake_resource" this {
+}
+`), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "plan-transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "/testTerraform",
+		mptfDirs: []string{"/testData"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	return fs, stub.Reset
+}
+
+func TestPlanTransformThenApplyPlan(t *testing.T) {
+	fs, reset := stubPlanTransformFs()
+	defer reset()
+
+	err := planTransform(false, "/plan.mptfplan", new(bytes.Buffer), context.Background())
+	require.NoError(t, err)
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" this {
+}
+`, string(content))
+	plan, err := readTransformPlan("/plan.mptfplan")
+	require.NoError(t, err)
+	require.Len(t, plan.Files, 2)
+	assert.Equal(t, "locals.tf", plan.Files[0].Path)
+	assert.Equal(t, "main.tf", plan.Files[1].Path)
+
+	err = applyTransformPlan("/plan.mptfplan", new(bytes.Buffer))
+	require.NoError(t, err)
+	content, err = afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" this {
+  tags = {}
+}
+`, string(content))
+	runs, err := backup.Runs("/testTerraform")
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Len(t, runs[0].Manifest.CreatedFiles, 1)
+	assert.Equal(t, "locals.tf", runs[0].Manifest.CreatedFiles[0].Path)
+	require.Len(t, runs[0].Manifest.Files, 1)
+	assert.Equal(t, "main.tf", runs[0].Manifest.Files[0].Path)
+}
+
+func TestApplyTransformPlanShouldRefuseStalePlan(t *testing.T) {
+	fs, reset := stubPlanTransformFs()
+	defer reset()
+
+	err := planTransform(false, "/plan.mptfplan", new(bytes.Buffer), context.Background())
+	require.NoError(t, err)
+	edited := `resource "fake_resource" this {
+  name = "edited"
+}
+`
+	require.NoError(t, afero.WriteFile(fs, "/testTerraform/main.tf", []byte(edited), 0644))
+
+	err = applyTransformPlan("/plan.mptfplan", new(bytes.Buffer))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "main.tf")
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, edited, string(content))
+	exist, err := afero.Exists(fs, "/testTerraform/locals.tf")
+	require.NoError(t, err)
+	assert.False(t, exist)
+}
+
+func TestApplyTransformPlan_ResetTransformShouldRevertItsChanges(t *testing.T) {
+	fs, reset := stubPlanTransformFs()
+	defer reset()
+
+	require.NoError(t, planTransform(false, "/plan.mptfplan", new(bytes.Buffer), context.Background()))
+	require.NoError(t, applyTransformPlan("/plan.mptfplan", new(bytes.Buffer)))
+
+	out := new(bytes.Buffer)
+	require.NoError(t, resetTransforms([]string{"transform.update_in_place.this"}, nil, out))
+	assert.Equal(t, "main.tf reverted\n", out.String())
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "resource \"fake_resource\" this {\n}\n", string(content))
+	exist, err := afero.Exists(fs, "/testTerraform/locals.tf")
+	require.NoError(t, err)
+	assert.True(t, exist)
+}
+
+func TestApplyTransformPlanShouldWriteNothingIfAnyFileFails(t *testing.T) {
+	fs, reset := stubPlanTransformFs()
+	defer reset()
+
+	require.NoError(t, planTransform(false, "/plan.mptfplan", new(bytes.Buffer), context.Background()))
+	stub := gostub.Stub(&filesystem.Fs, failRenameFs{Fs: fs, failOn: "/testTerraform/main.tf"})
+	defer stub.Reset()
+
+	require.Error(t, applyTransformPlan("/plan.mptfplan", new(bytes.Buffer)))
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "resource \"fake_resource\" this {\n}\n", string(content))
+	files, err := afero.Glob(fs, "/testTerraform/*.tf*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/testTerraform/main.tf"}, files)
+	runs, err := backup.Runs("/testTerraform")
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+}
+
+type failRenameFs struct {
+	afero.Fs
+	failOn string
+}
+
+func (f failRenameFs) Rename(oldname, newname string) error {
+	if newname == f.failOn {
+		return fmt.Errorf("rename %s failed", newname)
+	}
+	return f.Fs.Rename(oldname, newname)
+}
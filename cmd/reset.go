@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/Azure/mapotf/pkg"
 	"github.com/Azure/mapotf/pkg/backup"
@@ -9,26 +11,47 @@ import (
 )
 
 func NewResetCmd() *cobra.Command {
-	return &cobra.Command{
+	force := false
+	var transforms []string
+
+	resetCmd := &cobra.Command{
 		Use:   "reset",
-		Short: "Reset all transformed Terraform files, mapotf reset --tf-dir",
+		Short: "Reset all transformed Terraform files, or only the changes made by some transforms or mptf dirs, mapotf reset [--force] [--transform address] [--mptf-dir dir] --tf-dir",
 		FParseErrWhitelist: cobra.FParseErrWhitelist{
 			UnknownFlags: true,
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return reset()
-		},
+		RunE: withTfDirLock(func(cmd *cobra.Command, args []string) error {
+			if len(transforms) > 0 || len(cf.mptfDirs) > 0 {
+				return resetTransforms(transforms, cf.mptfDirs, cmd.OutOrStdout())
+			}
+			return reset(force)
+		}),
 	}
+
+	resetCmd.Flags().StringSliceVar(&transforms, "transform", nil, "Only revert the changes made by the given transform address, e.g. `transform.update_in_place.tags`. Use this option more than once to revert more than one transform.")
+	resetCmd.Flags().BoolVar(&force, "force", false, "Reset even if some files have been edited after mapotf changed them, these edits would be lost.")
+	return resetCmd
 }
 
-func reset() error {
-	moduleRefs, err := pkg.ModuleRefs(cf.tfDir)
+func reset(force bool) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	if err = backup.Reset(root, force); err != nil {
+		var editedErr *backup.EditedFilesError
+		if errors.As(err, &editedErr) {
+			return fmt.Errorf("%s\nrun `mapotf reset --force` to reset anyway", err.Error())
+		}
+		return err
+	}
+	moduleRefs, err := pkg.ModuleRefsSkippingBroken(cf.tfDir)
 	if err != nil {
 		return err
 	}
 	for _, tfDir := range moduleRefs {
 		d := tfDir
-		err = backup.Reset(d.AbsDir)
+		err = backup.ResetLegacyBackup(d.AbsDir)
 		if err != nil {
 			return err
 		}
@@ -37,6 +60,48 @@ func reset() error {
 	return nil
 }
 
+// resetTransforms reverts only the changes made by the given transforms or the transforms declared in the given mptf dirs,
+// changes made by other transforms are kept.
+func resetTransforms(transforms, mptfDirs []string, out io.Writer) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	changed, err := backup.RevertPatches(root, func(p backup.Patch) bool {
+		for _, t := range transforms {
+			if p.Transform == t {
+				return true
+			}
+		}
+		for _, dir := range mptfDirs {
+			if sameMptfDir(p.MptfDir, dir) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		_, _ = fmt.Fprintln(out, "No change to revert.")
+		return nil
+	}
+	for _, f := range changed {
+		_, _ = fmt.Fprintf(out, "%s reverted\n", displayPath(f))
+	}
+	return nil
+}
+
+func sameMptfDir(a, b string) bool {
+	if a == b {
+		return true
+	}
+	absA, errA := pkg.AbsDir(a)
+	absB, errB := pkg.AbsDir(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
 func init() {
 	rootCmd.AddCommand(NewResetCmd())
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/terraform"
 	"github.com/spf13/cobra"
 	"os"
 	"os/exec"
@@ -46,7 +48,9 @@ func init() {
 	}
 	rootCmd.PersistentFlags().StringVar(&cf.tfDir, "tf-dir", pwd, "Terraform directory")
 	rootCmd.PersistentFlags().StringSliceVar(&cf.mptfDirs, "mptf-dir", nil, "MPTF directory")
+	rootCmd.PersistentFlags().StringVar(&cf.format, "format", string(terraform.FormatTouched), "Format mode for changed files, `touched` formats only blocks modified by transforms, `all` formats the whole file, `none` keeps existing code as it is")
 
+	rootCmd.PersistentFlags().StringVar(&cf.tfBinary, "tf-binary", "", fmt.Sprintf("Terraform binary to run, e.g. `tofu`, default to `$%s`, or `terraform` or `tofu`, whichever is found in PATH first", pkg.TerraformBinaryEnv))
 	rootCmd.PersistentFlags().StringSlice("mptf-var", cf.mptfVars, "Set a value for one of the input variables in the root module of the configuration. Use this option more than once to set more than one variable.")
 	rootCmd.PersistentFlags().StringSlice("mptf-var-file", cf.mptfVarFiles, "Load variable values from the given file, in addition to the default files mptf.mptfvars and *.auto.mptfvars. Use this option more than once to include more than one variables file.")
 }
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	"github.com/mattn/go-isatty"
+)
+
+// recoverInterruptedSession offers to restore the files left transformed by an interrupted wrapped Terraform command, and
+// fails unless they are restored, so transforms never run again over transformed files. It must be called with the lock
+// held.
+func recoverInterruptedSession(in io.Reader, out io.Writer, interactive bool) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	s, err := backup.InterruptedSession(root)
+	if err != nil || s == nil {
+		return err
+	}
+	interruptedErr := fmt.Errorf("a previous mapotf session (PID %d, started at %s: %s) was interrupted before restoring the transformed Terraform files, run `mapotf reset --tf-dir %s` to restore them first", s.Pid, s.StartedAt.Local().Format(time.RFC3339), s.Command, cf.tfDir)
+	if !interactive {
+		return interruptedErr
+	}
+	_, _ = fmt.Fprintf(out, "A previous mapotf session (PID %d, started at %s: %s) was interrupted before restoring the transformed Terraform files.\n", s.Pid, s.StartedAt.Local().Format(time.RFC3339), s.Command)
+	_, _ = fmt.Fprint(out, "Do you want to restore them now? Only 'yes' will be accepted: ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		return interruptedErr
+	}
+	if err = backup.RecoverSession(root, s); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "Transformed files have been restored.")
+	return nil
+}
+
+func stdinIsTerminal() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
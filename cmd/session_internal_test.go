@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubInterruptedSession(t *testing.T) func() {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(`resource "fake_resource" this {
+}
+`), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&cf, &commonFlags{
+		tfDir: "/testTerraform",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	require.NoError(t, backup.StartSession("/testTerraform", "mapotf apply"))
+	run, err := backup.NewRun("/testTerraform")
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder("/testTerraform"))
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte(`resource "fake_resource" this {
+  tags = {}
+}
+`), 0644)
+	require.NoError(t, run.Save())
+	return stub.Reset
+}
+
+func TestRecoverInterruptedSession_ShouldRestoreOnYes(t *testing.T) {
+	defer stubInterruptedSession(t)()
+	out := new(bytes.Buffer)
+	require.NoError(t, recoverInterruptedSession(strings.NewReader("yes\n"), out, true))
+	assert.Contains(t, out.String(), "was interrupted")
+	assert.Contains(t, out.String(), "Transformed files have been restored.")
+	content, err := afero.ReadFile(filesystem.Fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "resource \"fake_resource\" this {\n}\n", string(content))
+	s, err := backup.InterruptedSession("/testTerraform")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestRecoverInterruptedSession_ShouldFailWithoutRestore(t *testing.T) {
+	cases := []struct {
+		desc        string
+		answer      string
+		interactive bool
+	}{
+		{desc: "no terminal"},
+		{desc: "answer no", answer: "no\n", interactive: true},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			defer stubInterruptedSession(t)()
+			err := recoverInterruptedSession(strings.NewReader(c.answer), new(bytes.Buffer), c.interactive)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "mapotf reset --tf-dir /testTerraform")
+			content, err := afero.ReadFile(filesystem.Fs, "/testTerraform/main.tf")
+			require.NoError(t, err)
+			assert.Contains(t, string(content), "tags")
+			s, err := backup.InterruptedSession("/testTerraform")
+			require.NoError(t, err)
+			assert.NotNil(t, s)
+			require.Error(t, backup.StartSession("/testTerraform", "mapotf plan"))
+		})
+	}
+}
@@ -39,7 +39,7 @@ var terraformCmds = map[string]terraformCommand{
 		transform: false,
 	},
 	"force-unlock": {
-		d:         "Release a stuck lock on the current workspace",
+		d:         "Release a stuck lock on the current workspace, or the lock of mapotf itself if no lock id is given",
 		transform: true,
 	},
 	"get": {
@@ -115,9 +115,15 @@ func init() {
 		info := s
 		cmd := key
 		recursive := false
-		run := wrapTerraformCommand(cf.tfDir, cmd)
+		keep := false
+		keepOnFailure := false
+		isolated := false
+		run := wrapTerraformCommand(cmd)
 		if info.transform {
-			run = wrapTerraformCommandWithEphemeralTransform(cf.tfDir, cmd, &recursive)
+			run = withTfDirLock(wrapTerraformCommandWithEphemeralTransform(cmd, &recursive, &keep, &keepOnFailure, &isolated))
+		}
+		if cmd == "force-unlock" {
+			run = wrapForceUnlock(run)
 		}
 		c := &cobra.Command{
 			Use:   cmd,
@@ -129,6 +135,11 @@ func init() {
 		}
 
 		c.Flags().BoolVarP(&recursive, "recursive", "r", false, "With transforms to all modules or not, default to the root module only.")
+		if info.transform {
+			c.Flags().BoolVar(&keep, "keep", false, "Keep the transformed files after Terraform exits, restore them later by `mapotf reset`.")
+			c.Flags().BoolVar(&keepOnFailure, "keep-on-failure", false, "Keep the transformed files if Terraform fails, so you can inspect what it choked on, restore them later by `mapotf reset`.")
+			c.Flags().BoolVar(&isolated, "isolated", false, "Apply transforms to a copy of the Terraform directory and its installed modules and run Terraform there, with the same state and backend config, so the source tree is never changed.")
+		}
 		rootCmd.AddCommand(c)
 		terraformCommands = append(terraformCommands, c)
 	}
@@ -1,35 +1,144 @@
 package cmd
 
 import (
-	"github.com/spf13/cobra"
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	"github.com/spf13/cobra"
 )
 
-func wrapTerraformCommandWithEphemeralTransform(tfDir, tfCmd string, recursive *bool) func(*cobra.Command, []string) error {
-	return func(cmd *cobra.Command, args []string) error {
-		restores, err := transform(*recursive, cmd.Context())
+// interruptSignals are caught while Terraform is running, so mapotf outlives Terraform and restores the transformed files
+// after Terraform has exited.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+func wrapTerraformCommandWithEphemeralTransform(tfCmd string, recursive, keep, keepOnFailure, isolated *bool) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) (err error) {
+		// Fail before changing any file if there's no Terraform binary to run.
+		if _, err = pkg.FindTerraformBinary(cf.tfBinary); err != nil {
+			return err
+		}
+		if err = recoverInterruptedSession(os.Stdin, cmd.ErrOrStderr(), stdinIsTerminal()); err != nil {
+			return err
+		}
+		// An interrupted session is recovered first, so its transformed files won't be copied into the isolated workspace.
+		if *isolated {
+			return runIsolated(tfCmd, *recursive, *keep, *keepOnFailure, cmd, args)
+		}
+		root, err := pkg.AbsDir(cf.tfDir)
+		if err != nil {
+			return err
+		}
+		// The session marker is left on disk if mapotf is killed before the restore, so the next run can recover it.
+		if err = backup.StartSession(root, commandLine()); err != nil {
+			return err
+		}
+		run, err := transform(*recursive, cmd.Context())
+		if run == nil {
+			_ = backup.EndSession(root)
+			return err
+		}
+		defer func() {
+			if *keep || (*keepOnFailure && err != nil) {
+				_, _ = fmt.Fprintf(os.Stderr, "Transformed files are kept for inspection, their backups are in %s.\nRun `mapotf reset` to restore them.\n", run.Dir())
+				_ = backup.EndSession(root)
+				return
+			}
+			if restoreErr := run.Restore(); restoreErr != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "cannot restore transformed files: %+v\nrun `mapotf reset` to restore them\n", restoreErr)
+				return
+			}
+			_ = backup.EndSession(root)
+		}()
 		if err != nil {
 			return err
 		}
-		for _, restore := range restores {
-			r := restore
-			defer r()
+		// Don't start Terraform if mapotf has been interrupted while applying transforms.
+		if err = cmd.Context().Err(); err != nil {
+			return err
 		}
-		return wrapTerraformCommand(tfDir, tfCmd)(cmd, args)
+		return wrapTerraformCommand(tfCmd)(cmd, args)
 	}
 }
 
-func wrapTerraformCommand(tfDir, cmd string) func(*cobra.Command, []string) error {
+func wrapTerraformCommand(cmd string) func(*cobra.Command, []string) error {
 	return func(c *cobra.Command, args []string) error {
 		tfArgs := append([]string{cmd}, NonMptfArgs...)
-		tfCmd := exec.CommandContext(c.Context(), "terraform", tfArgs...)
-		tfCmd.Dir = tfDir
+		// Terraform is not killed when the context is cancelled, it receives the signals instead, so it can release the
+		// state lock and exit gracefully.
+		binary, err := pkg.FindTerraformBinary(cf.tfBinary)
+		if err != nil {
+			return err
+		}
+		tfCmd := exec.Command(binary, tfArgs...)
+		// `--tf-dir` is read when the command runs, flags are not parsed yet when commands are created.
+		tfCmd.Dir = cf.tfDir
 		tfCmd.Stdin = os.Stdin
 		tfCmd.Stdout = os.Stdout
 		tfCmd.Stderr = os.Stderr
-		// Run the command and pass through exit code
-		err := tfCmd.Run()
-		return err
+		if err := tfCmd.Start(); err != nil {
+			return err
+		}
+		stop := forwardSignals(tfCmd.Process, stdinIsTerminal())
+		defer stop()
+		// Wait for the command and pass through exit code
+		return tfCmd.Wait()
+	}
+}
+
+var terraformVersions = struct {
+	sync.Mutex
+	versions map[string]string
+}{versions: make(map[string]string)}
+
+// terraformVersion returns the version of the Terraform binary, or an empty string if the binary cannot be found or
+// run, so transforms that don't care about the version still work without Terraform installed.
+func terraformVersion() string {
+	binary, err := pkg.FindTerraformBinary(cf.tfBinary)
+	if err != nil {
+		return ""
+	}
+	terraformVersions.Lock()
+	defer terraformVersions.Unlock()
+	if v, ok := terraformVersions.versions[binary]; ok {
+		return v
+	}
+	v, err := pkg.DetectTerraformVersion(binary)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s, `mptf.terraform_version` is null\n", err.Error())
+	}
+	terraformVersions.versions[binary] = v
+	return v
+}
+
+// forwardSignals forwards interrupt signals received by mapotf to the Terraform process until the returned func is
+// called. A Ctrl+C in a terminal is sent to Terraform by the terminal already, forwarding it again would be taken by
+// Terraform as a second interrupt, which stops it immediately, so interrupts are only forwarded without a terminal.
+func forwardSignals(p *os.Process, terminal bool) func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, interruptSignals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				_, _ = fmt.Fprintf(os.Stderr, "%s received, waiting for Terraform to exit before restoring transformed files...\n", sig.String())
+				if sig == os.Interrupt && terminal {
+					continue
+				}
+				_ = p.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
 	}
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg/backup"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const wrapperTestOriginalContent = `resource "fake_resource" this {
+}
+`
+
+func runWrappedTerraformCommand(t *testing.T, tfBinary string, keep, keepOnFailure, isolated bool) (string, error) {
+	tfDir := t.TempDir()
+	mptfDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tfDir, "main.tf"), []byte(wrapperTestOriginalContent), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mptfDir, "main.mptf.hcl"), []byte(`
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+`), 0644))
+	stub := gostub.Stub(&os.Args, []string{"mapotf", "plan"}).Stub(&cf, &commonFlags{
+		tfDir:    tfDir,
+		mptfDirs: []string{mptfDir},
+		format:   "touched",
+		tfBinary: tfBinary,
+	}).Stub(&NonMptfArgs, []string(nil))
+	defer stub.Reset()
+
+	recursive := false
+	c := &cobra.Command{}
+	c.SetContext(context.Background())
+	err := wrapTerraformCommandWithEphemeralTransform("plan", &recursive, &keep, &keepOnFailure, &isolated)(c, nil)
+	content, readErr := os.ReadFile(filepath.Join(tfDir, "main.tf"))
+	require.NoError(t, readErr)
+	session, sessionErr := backup.InterruptedSession(tfDir)
+	require.NoError(t, sessionErr)
+	assert.Nil(t, session)
+	return string(content), err
+}
+
+func TestWrapTerraformCommand_ShouldRestoreOnFailureByDefault(t *testing.T) {
+	content, err := runWrappedTerraformCommand(t, "false", false, false, false)
+	require.Error(t, err)
+	assert.Equal(t, wrapperTestOriginalContent, content)
+}
+
+func TestWrapTerraformCommand_KeepOnFailure(t *testing.T) {
+	content, err := runWrappedTerraformCommand(t, "false", false, true, false)
+	require.Error(t, err)
+	assert.Contains(t, content, "tags = {}")
+
+	content, err = runWrappedTerraformCommand(t, "true", false, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, wrapperTestOriginalContent, content)
+}
+
+func TestWrapTerraformCommand_Keep(t *testing.T) {
+	content, err := runWrappedTerraformCommand(t, "true", true, false, false)
+	require.NoError(t, err)
+	assert.Contains(t, content, "tags = {}")
+}
+
+func TestWrapTerraformCommand_IsolatedShouldNotChangeSourceTree(t *testing.T) {
+	content, err := runWrappedTerraformCommand(t, "true", false, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, wrapperTestOriginalContent, content)
+}
@@ -6,100 +6,216 @@ import (
 	"github.com/Azure/golden"
 	"github.com/Azure/mapotf/pkg"
 	"github.com/Azure/mapotf/pkg/backup"
+	"github.com/Azure/mapotf/pkg/terraform"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
 )
 
 func NewTransformCmd() *cobra.Command {
 	recursive := false
+	dryRun := false
+	check := false
+	output := outputText
+	planFile := ""
+	patchFile := ""
+	gitBranch := ""
+	gitCommitMessage := ""
+	rootsFile := ""
+	parallelism := defaultParallelism
 
 	transformCmd := &cobra.Command{
 		Use:   "transform",
-		Short: "Apply the transforms, mapotf transform [-r] --tf-dir [] --mptf-dir  [path to config files], support mutilple mptf dirs",
+		Short: "Apply the transforms, mapotf transform [-r] [--dry-run|--check|--plan plan.mptfplan|--emit-patch out.patch|--git-branch mptf/<name>] --tf-dir [] --mptf-dir  [path to config files], support mutilple mptf dirs",
 		FParseErrWhitelist: cobra.FParseErrWhitelist{
 			UnknownFlags: true,
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJson {
+				return fmt.Errorf("invalid output %s, must be one of `%s` or `%s`", output, outputText, outputJson)
+			}
+			if multiRootMode(rootsFile) {
+				if dryRun || check || planFile != "" || patchFile != "" || gitBranch != "" || output != outputText {
+					return fmt.Errorf("`--roots-file` and globs in `--tf-dir` cannot be used with `--dry-run`, `--check`, `--plan`, `--emit-patch`, `--git-branch` or `--output %s`", outputJson)
+				}
+				roots, err := resolveRoots(cf.tfDir, rootsFile)
+				if err != nil {
+					return err
+				}
+				return transformRoots(roots, recursive, parallelism, cmd.OutOrStdout(), cmd.Context())
+			}
+			// `--check`, `--emit-patch` and `--dry-run` don't write any Terraform file, they don't need the lock.
+			readOnly := check || patchFile != "" || dryRun
+			if !readOnly {
+				unlock, err := lockTfDir()
+				if err != nil {
+					return err
+				}
+				defer unlock()
+				if err = recoverInterruptedSession(os.Stdin, cmd.ErrOrStderr(), stdinIsTerminal()); err != nil {
+					return err
+				}
+			}
+			if planFile != "" {
+				return applyTransformPlan(planFile, cmd.OutOrStdout())
+			}
+			if check {
+				return checkTransform(recursive, output, cmd.OutOrStdout(), cmd.Context())
+			}
+			if patchFile != "" {
+				return emitPatch(recursive, patchFile, cmd.OutOrStdout(), cmd.Context())
+			}
+			if gitBranch != "" {
+				return transformToGitBranch(recursive, gitBranch, gitCommitMessage, cmd.OutOrStdout(), cmd.Context())
+			}
+			if dryRun {
+				return dryRunTransform(recursive, output, cmd.OutOrStdout(), cmd.Context())
+			}
+			if output == outputJson {
+				return transformWithJsonOutput(recursive, cmd.OutOrStdout(), cmd.Context())
+			}
 			_, err := transform(recursive, cmd.Context())
 			return err
 		},
 	}
 
 	transformCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Apply transforms to all modules or not, default to the root module only.")
+	transformCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the changes as unified diff without writing any file, backup files included.")
+	transformCmd.Flags().StringVar(&output, "output", outputText, "Output format, `text` or `json`. The json output follows a versioned schema.")
+	transformCmd.Flags().StringVar(&planFile, "plan", "", "Apply the changes saved by `mapotf plan-transform -out`, refuse if any Terraform file has changed since the plan was created.")
+	transformCmd.Flags().StringVar(&patchFile, "emit-patch", "", "Write the changes to the given file as a git-format patch, with paths relative to the root of the git repository, instead of changing any Terraform file.")
+	transformCmd.Flags().StringVar(&gitBranch, "git-branch", "", "Commit the changed and created Terraform files to a new local git branch, e.g. `mptf/<name>`. Backup files are not committed.")
+	transformCmd.Flags().StringVar(&gitCommitMessage, "git-commit-message", defaultGitCommitMessage, "Message of the commit created by `--git-branch`, applied transforms and mptf sources are appended.")
+	transformCmd.Flags().StringVar(&rootsFile, "roots-file", "", "Apply the transforms to every root module listed in the file instead of `--tf-dir`, one dir or glob relative to the file per line. `--tf-dir` accepts a glob like `envs/*` too.")
+	transformCmd.Flags().IntVar(&parallelism, "parallelism", defaultParallelism, "Number of root modules transformed at the same time with `--roots-file` or a glob in `--tf-dir`.")
+	transformCmd.Flags().BoolVar(&check, "check", false, "Exit with non-zero code if any file would be changed by the transforms, without writing any file.")
+	transformCmd.MarkFlagsMutuallyExclusive("check", "dry-run", "plan", "emit-patch", "git-branch")
 	return transformCmd
 }
 
-func transform(recursive bool, ctx context.Context) ([]func(), error) {
-	var restore []func()
-	varFlags, err := varFlags(os.Args)
+// transform applies the transforms and returns the backup run that can restore the changed files.
+func transform(recursive bool, ctx context.Context) (*backup.Run, error) {
+	moduleRefs, err := transformModuleRefs(cf.tfDir, recursive)
 	if err != nil {
 		return nil, err
 	}
-	rootMod, err := pkg.NewTerraformRootModuleRef(cf.tfDir)
+	run, err := backupModules(cf.tfDir, moduleRefs)
 	if err != nil {
 		return nil, err
 	}
-	moduleRefs := []*pkg.TerraformModuleRef{
-		rootMod,
+	journal := terraform.NewWriteJournal()
+	if err = applyTransforms(moduleRefs, journal, os.Stdout, ctx); err != nil {
+		_ = run.Discard()
+		return nil, err
 	}
-	if recursive {
-		modulePaths, err := pkg.ModuleRefs(cf.tfDir)
-		if err != nil {
-			return nil, err
-		}
-		moduleRefs = modulePaths
+	if err = saveRun(run, journal); err != nil {
+		return run, err
+	}
+	fmt.Println("Transforms applied successfully.")
+	return run, nil
+}
+
+// backupModules backs up the Terraform files of all modules in a new run of the backup store under the root module in
+// tfDir.
+func backupModules(tfDir string, moduleRefs []*pkg.TerraformModuleRef) (*backup.Run, error) {
+	root, err := pkg.AbsDir(tfDir)
+	if err != nil {
+		return nil, err
+	}
+	run, err := backup.NewRun(root)
+	if err != nil {
+		return nil, err
 	}
 	for _, moduleRef := range moduleRefs {
-		d := moduleRef
-		err = backup.BackupFolder(d.AbsDir)
-		restore = append(restore, func() {
-			_ = backup.Reset(d.AbsDir)
-		})
-		if err != nil {
-			return restore, err
+		if err = run.BackupFolder(moduleRef.AbsDir); err != nil {
+			_ = run.Discard()
+			return nil, err
 		}
 	}
-	var mptfDirs []string
-	for _, dir := range cf.mptfDirs {
-		localizedDir, dispose, err := localizeConfigFolder(dir, ctx)
-		if err != nil {
-			return restore, err
-		}
-		if dispose != nil {
-			defer dispose()
-		}
-		mptfDirs = append(mptfDirs, localizedDir)
+	return run, nil
+}
+
+// saveRun records the changes made by each transform into the backup run, then saves it.
+func saveRun(run *backup.Run, journal *terraform.WriteJournal) error {
+	for _, c := range journal.FileChanges() {
+		run.AddPatch(c.File, backup.NewPatch(c.Writer.Address, c.Writer.Source, journal.MptfDir(c.Writer.Source), c.Created, c.Before, c.After))
+	}
+	return run.Save()
+}
+
+func transformModuleRefs(tfDir string, recursive bool) ([]*pkg.TerraformModuleRef, error) {
+	if recursive {
+		return pkg.ModuleRefs(tfDir)
+	}
+	rootMod, err := pkg.NewTerraformRootModuleRef(tfDir)
+	if err != nil {
+		return nil, err
+	}
+	return []*pkg.TerraformModuleRef{
+		rootMod,
+	}, nil
+}
+
+func applyTransforms(moduleRefs []*pkg.TerraformModuleRef, journal *terraform.WriteJournal, out io.Writer, ctx context.Context) error {
+	return applyMptfDirs(cf.mptfDirs, moduleRefs, journal, out, ctx)
+}
+
+func applyMptfDirs(dirs []string, moduleRefs []*pkg.TerraformModuleRef, journal *terraform.WriteJournal, out io.Writer, ctx context.Context) error {
+	mptfDirs, err := localizeMptfDirs(dirs, ctx)
+	if err != nil {
+		return err
+	}
+	defer disposeMptfDirs(mptfDirs)
+	pkg.TerraformVersion = terraformVersion()
+	return applyLocalizedMptfDirs(cf.tfDir, mptfDirs, moduleRefs, journal, out, ctx)
+}
+
+// applyLocalizedMptfDirs applies mptf dirs that have been downloaded already to the modules of the root module in tfDir.
+// The changed files are written only once all transforms have succeeded.
+func applyLocalizedMptfDirs(tfDir string, mptfDirs []localizedMptfDir, moduleRefs []*pkg.TerraformModuleRef, journal *terraform.WriteJournal, out io.Writer, ctx context.Context) error {
+	varFlags, err := varFlags(os.Args)
+	if err != nil {
+		return err
 	}
+	formatMode, err := terraform.ParseFormatMode(cf.format)
+	if err != nil {
+		return err
+	}
+	for _, mptfDir := range mptfDirs {
+		journal.SetMptfDir(mptfDir.path, mptfDir.original)
+	}
+	stage := terraform.NewStage()
 	for _, mptfDir := range mptfDirs {
-		hclBlocks, err := pkg.LoadMPTFHclBlocks(false, mptfDir)
+		hclBlocks, err := pkg.LoadMPTFHclBlocks(false, mptfDir.path)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, tfDir := range moduleRefs {
-			err = applyTransform(tfDir, hclBlocks, varFlags, ctx)
+		for _, m := range moduleRefs {
+			err = applyTransform(tfDir, m, hclBlocks, varFlags, journal, stage, formatMode, out, ctx)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
-	fmt.Println("Transforms applied successfully.")
-	return restore, nil
+	return stage.Commit()
 }
 
-func applyTransform(m *pkg.TerraformModuleRef, hclBlocks []*golden.HclBlock, varFlags []golden.CliFlagAssignedVariables, ctx context.Context) error {
-	cfg, err := pkg.NewMetaProgrammingTFConfig(m, &cf.tfDir, hclBlocks, varFlags, ctx)
+func applyTransform(tfDir string, m *pkg.TerraformModuleRef, hclBlocks []*golden.HclBlock, varFlags []golden.CliFlagAssignedVariables, journal *terraform.WriteJournal, stage *terraform.Stage, formatMode terraform.FormatMode, out io.Writer, ctx context.Context) error {
+	cfg, err := pkg.NewStagedMetaProgrammingTFConfig(m, stage, &tfDir, hclBlocks, varFlags, ctx)
 	if err != nil {
 		return err
 	}
+	cfg.UseWriteJournal(journal)
+	cfg.SetFormatMode(formatMode)
 	plan, err := pkg.RunMetaProgrammingTFPlan(cfg)
 	if err != nil {
 		return err
 	}
 	if len(plan.Transforms) == 0 {
-		fmt.Println("No transforms to apply.")
+		_, _ = fmt.Fprintln(out, "No transforms to apply.")
 		return nil
 	}
-	fmt.Println(plan.String())
+	_, _ = fmt.Fprintln(out, plan.String())
 	err = plan.Apply()
 	if err != nil {
 		return fmt.Errorf("error applying plan: %s\n", err.Error())
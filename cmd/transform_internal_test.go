@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const firstMptfDir = `
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "new_block" locals {
+  new_block_type = "locals"
+  filename       = "locals.tf"
+  asraw {
+    a = 1
+  }
+}
+`
+
+func stubTwoMptfDirs(t *testing.T, second string) afero.Fs {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/mptf1/main.mptf.hcl", []byte(firstMptfDir), 0644)
+	_ = afero.WriteFile(fs, "/mptf2/main.mptf.hcl", []byte(second), 0644)
+	_ = afero.WriteFile(fs, "/testTerraform/main.tf", []byte("resource \"fake_resource\" this {\n}\n"), 0644)
+	stub := gostub.Stub(&filesystem.Fs, fs).Stub(&os.Args, []string{"mapotf", "transform"}).Stub(&cf, &commonFlags{
+		tfDir:    "/testTerraform",
+		mptfDirs: []string{"/mptf1", "/mptf2"},
+		format:   "touched",
+	}).Stub(&pkg.AbsDir, func(dir string) (string, error) {
+		return dir, nil
+	})
+	t.Cleanup(stub.Reset)
+	return fs
+}
+
+func TestTransform_LaterMptfDirShouldSeeEarlierChanges(t *testing.T) {
+	fs := stubTwoMptfDirs(t, `
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    name = "this"
+  }
+}
+`)
+
+	run, err := transform(false, context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "tags = {}")
+	assert.Contains(t, string(content), `name = "this"`)
+	exists, err := afero.Exists(fs, "/testTerraform/locals.tf")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestTransform_FailedMptfDirShouldLeaveNoChange(t *testing.T) {
+	fs := stubTwoMptfDirs(t, `
+transform "update_in_place" this {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {
+      env = "dev"
+    }
+  }
+}
+`)
+
+	run, err := transform(false, context.Background())
+	require.Error(t, err)
+	assert.Nil(t, run)
+	content, err := afero.ReadFile(fs, "/testTerraform/main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "resource \"fake_resource\" this {\n}\n", string(content))
+	files, err := afero.Glob(fs, "/testTerraform/*.tf*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/testTerraform/main.tf"}, files)
+	runs, err := backup.Runs("/testTerraform")
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+}
+
+func TestTransformCmd_ModeFlagsShouldBeMutuallyExclusive(t *testing.T) {
+	stubTwoMptfDirs(t, "")
+	for _, args := range [][]string{
+		{"--check", "--git-branch", "mptf/test"},
+		{"--check", "--dry-run"},
+		{"--dry-run", "--emit-patch", "out.patch"},
+		{"--plan", "plan.mptfplan", "--git-branch", "mptf/test"},
+	} {
+		transformCmd := NewTransformCmd()
+		transformCmd.SetArgs(args)
+		transformCmd.SetOut(io.Discard)
+		transformCmd.SetErr(io.Discard)
+		err := transformCmd.ExecuteContext(context.Background())
+		require.ErrorContains(t, err, "none of the others can be", args)
+	}
+}
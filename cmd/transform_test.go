@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/Azure/mapotf/cmd"
 	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
 	filesystem "github.com/Azure/mapotf/pkg/fs"
 	"github.com/prashantv/gostub"
 	"github.com/spf13/afero"
@@ -72,11 +73,9 @@ resource "fake_resource" that {
 }
 `
 	assert.Equal(t, expected, tfFileStr)
-	backupTfFilePath := "/testTerraform/main.tf.mptfbackup"
-	exists, err := afero.Exists(fs, backupTfFilePath)
+	originals, err := backup.OriginalFiles("/testTerraform")
 	require.NoError(t, err)
-	assert.True(t, exists)
-	backupFileContent, err := afero.ReadFile(fs, backupTfFilePath)
-	require.NoError(t, err)
-	assert.Equal(t, terraformCode, string(backupFileContent))
+	require.Len(t, originals, 1)
+	assert.Equal(t, "/testTerraform/main.tf", originals[0].Path)
+	assert.Equal(t, terraformCode, string(originals[0].Content))
 }
@@ -15,11 +15,14 @@ type commonFlags struct {
 	mptfDirs     []string
 	mptfVars     []string
 	mptfVarFiles []string
+	format       string
+	tfBinary     string
 }
 
 type localizedMptfDir struct {
-	path    string
-	dispose func()
+	path     string
+	original string
+	dispose  func()
 }
 
 func (l localizedMptfDir) Dispose() {
@@ -29,33 +32,49 @@ func (l localizedMptfDir) Dispose() {
 }
 
 func (c *commonFlags) MptfDirs(ctx context.Context) ([]localizedMptfDir, error) {
+	return localizeMptfDirs(c.mptfDirs, ctx)
+}
+
+// localizeMptfDirs downloads the remote mptf dirs into temp dirs, local dirs are used as they are.
+func localizeMptfDirs(dirs []string, ctx context.Context) ([]localizedMptfDir, error) {
 	var r []localizedMptfDir
-	for _, originalDir := range c.mptfDirs {
+	for _, originalDir := range dirs {
 		localizedPath, disposeFunc, err := localizeConfigFolder(originalDir, ctx)
 		if err != nil {
-			for _, localizedDir := range r {
-				localizedDir.Dispose()
+			if disposeFunc != nil {
+				disposeFunc()
 			}
+			disposeMptfDirs(r)
 			return nil, fmt.Errorf("cannot get config path: %s: %+v", originalDir, err)
 		}
-		r = append(r, localizedMptfDir{path: localizedPath, dispose: disposeFunc})
+		r = append(r, localizedMptfDir{path: localizedPath, original: originalDir, dispose: disposeFunc})
 	}
 	return r, nil
 }
 
+func disposeMptfDirs(dirs []localizedMptfDir) {
+	for _, d := range dirs {
+		d.Dispose()
+	}
+}
+
 func varFlags(args []string) ([]golden.CliFlagAssignedVariables, error) {
 	var flags []golden.CliFlagAssignedVariables
 	for i := 0; i < len(args); i++ {
-		if args[i] != "--mptf-var" && args[i] != "--mptf-var-file" {
+		// Both `--mptf-var key=value` and `--mptf-var=key=value` are accepted.
+		name, arg, withValue := strings.Cut(args[i], "=")
+		if name != "--mptf-var" && name != "--mptf-var-file" {
 			continue
 		}
-		if i+1 == len(args) {
-			return nil, errors.New("missing value for " + args[i])
+		if !withValue {
+			if i+1 == len(args) {
+				return nil, errors.New("missing value for " + name)
+			}
+			arg = args[i+1]
+			i++ // skip next arg
 		}
-		arg := args[i+1]
-		if args[i] == "--mptf-var-file" {
+		if name == "--mptf-var-file" {
 			flags = append(flags, golden.NewCliFlagAssignedVariableFile(arg))
-			i++
 			continue
 		}
 		varAssignment := strings.Split(arg, "=")
@@ -63,7 +82,6 @@ func varFlags(args []string) ([]golden.CliFlagAssignedVariables, error) {
 			return nil, fmt.Errorf("the given --mptf option \"%s\" is not correctly specified. Must be a variable name and value separated by an equals sign, like --mptf-var key=value", arg)
 		}
 		flags = append(flags, golden.NewCliFlagAssignedVariable(varAssignment[0], varAssignment[1]))
-		i++ // skip next arg
 	}
 	return flags, nil
 }
@@ -39,3 +39,29 @@ func TestVarFlagsWithoutVarAssignment(t *testing.T) {
 	assert.NotNil(t, err, "Expected error but got nil")
 	assert.Contains(t, err.Error(), "missing value for --mptf-var")
 }
+
+func TestVarFlagsWithEqualSignForm(t *testing.T) {
+	args := []string{"mapotf", "transform", "--mptf-var=v=fromflag", "--mptf-var-file=dev.mptfvars", "--mptf-var", "w=1"}
+	expected := []golden.CliFlagAssignedVariables{
+		golden.NewCliFlagAssignedVariable("v", "fromflag"),
+		golden.NewCliFlagAssignedVariableFile("dev.mptfvars"),
+		golden.NewCliFlagAssignedVariable("w", "1"),
+	}
+
+	result, err := varFlags(args)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestVarFlagsShouldParseFilteredEqualSignForm(t *testing.T) {
+	mptfArgs, _ := FilterArgs([]string{"mapotf", "transform", "-mptf-var=v=fromflag", "-var=x=1"})
+	expected := []golden.CliFlagAssignedVariables{
+		golden.NewCliFlagAssignedVariable("v", "fromflag"),
+	}
+
+	result, err := varFlags(mptfArgs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
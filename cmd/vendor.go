@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/Azure/mapotf/pkg/vendoring"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func NewVendorCmd() *cobra.Command {
+	dest := ""
+	upgrade := false
+	version := ""
+	source := ""
+
+	vendorCmd := &cobra.Command{
+		Use:   "vendor",
+		Short: "Copy an installed module into the repository and call it from there, or upgrade a vendored module, mapotf vendor <module key> [--dir vendor/<module key>] [--upgrade --version X [--source source]] [--mptf-dir dir] --tf-dir",
+		FParseErrWhitelist: cobra.FParseErrWhitelist{
+			UnknownFlags: true,
+		},
+		RunE: withTfDirLock(func(cmd *cobra.Command, args []string) error {
+			var keys []string
+			for _, arg := range append(append([]string{}, args...), NonMptfArgs...) {
+				if !strings.HasPrefix(arg, "-") {
+					keys = append(keys, arg)
+				}
+			}
+			if len(keys) != 1 {
+				return fmt.Errorf("expect exactly one module key, e.g. `mapotf vendor vnet`, got %d", len(keys))
+			}
+			if upgrade {
+				return upgradeVendoredModule(keys[0], dest, source, version, cmd.OutOrStdout(), cmd.Context())
+			}
+			return vendorModule(keys[0], dest, cmd.OutOrStdout(), cmd.Context())
+		}),
+	}
+
+	vendorCmd.Flags().StringVar(&dest, "dir", "", "Directory to copy the module into, relative to the Terraform directory, default to `vendor/<module key>`.")
+	vendorCmd.Flags().BoolVar(&upgrade, "upgrade", false, "Upgrade the vendored module to a new version, the recorded mptf dirs are applied to the new version, and local edits are merged into it.")
+	vendorCmd.Flags().StringVar(&version, "version", "", "Version to upgrade to, required for modules from a registry.")
+	vendorCmd.Flags().StringVar(&source, "source", "", "Source to upgrade from, e.g. `git::https://github.com/org/module.git?ref=v2.0.0` or a local path, default to the recorded source.")
+	return vendorCmd
+}
+
+// vendorModule vendors the module, then applies the given mptf dirs to the vendored copy and records them, so they can
+// be applied again when the module is upgraded.
+func vendorModule(key, dest string, out io.Writer, ctx context.Context) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	if dest == "" {
+		dest = filepath.Join("vendor", key)
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(root, dest)
+	}
+	record, err := vendoring.Vendor(root, key, dest)
+	if err != nil {
+		return err
+	}
+	rel, _ := filepath.Rel(root, dest)
+	_, _ = fmt.Fprintf(out, "Module %s has been vendored into %s, it was %s.\n", key, rel, moduleVersionString(record.Source, record.Version))
+	if len(cf.mptfDirs) == 0 {
+		return nil
+	}
+	manifest, err := pkg.LoadModuleManifest(root)
+	if err != nil {
+		return err
+	}
+	moduleRef, err := pkg.NewTerraformModuleRef(dest, key, manifest.Module(key).Get("Source"), "")
+	if err != nil {
+		return err
+	}
+	// No backup is taken, the pristine copy is kept in the vendored module already.
+	if err = applyTransforms([]*pkg.TerraformModuleRef{moduleRef}, terraform.NewWriteJournal(), out, ctx); err != nil {
+		return err
+	}
+	record.SetMptfDirs(dest, cf.mptfDirs)
+	return record.Save(dest)
+}
+
+// upgradeVendoredModule fetches the new version of a vendored module, then merges the changes from the previous version
+// into the vendored module. The recorded mptf dirs are applied to both versions first, so only the upstream changes and
+// the changes made to the transforms' output are merged, and local edits are kept.
+func upgradeVendoredModule(key, dest, source, version string, out io.Writer, ctx context.Context) error {
+	root, err := pkg.AbsDir(cf.tfDir)
+	if err != nil {
+		return err
+	}
+	dir, err := vendoredModuleDir(root, key, dest)
+	if err != nil {
+		return err
+	}
+	record, err := vendoring.ReadRecord(dir)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("cannot find vendor record in %s, run `mapotf vendor %s` first", dir, key)
+	}
+	if source == "" {
+		source = record.Source
+	}
+	mptfDirs := record.ResolveMptfDirs(dir)
+	if len(cf.mptfDirs) > 0 {
+		mptfDirs = cf.mptfDirs
+	}
+	tmp := filepath.Join(os.TempDir(), "mapotf-"+uuid.NewString())
+	defer func() {
+		_ = filesystem.Fs.RemoveAll(tmp)
+	}()
+	upstream, err := vendoring.Fetch(ctx, source, version, filepath.Join(tmp, "upstream"))
+	if err != nil {
+		return err
+	}
+	base, theirs := filepath.Join(tmp, "base"), filepath.Join(tmp, "theirs")
+	if err = filesystem.CopyDir(vendoring.PristinePath(dir), base, nil); err != nil {
+		return err
+	}
+	if err = filesystem.CopyDir(upstream, theirs, func(rel string) bool {
+		return filepath.Base(rel) == ".git"
+	}); err != nil {
+		return err
+	}
+	if len(mptfDirs) > 0 {
+		var moduleRefs []*pkg.TerraformModuleRef
+		for _, d := range []string{base, theirs} {
+			moduleRef, err := pkg.NewTerraformModuleRef(d, key, source, version)
+			if err != nil {
+				return err
+			}
+			moduleRefs = append(moduleRefs, moduleRef)
+		}
+		if err = applyMptfDirs(mptfDirs, moduleRefs, terraform.NewWriteJournal(), io.Discard, ctx); err != nil {
+			return err
+		}
+	}
+	results, err := vendoring.Merge(dir, base, theirs, moduleVersionString(source, version))
+	if err != nil {
+		return err
+	}
+	if err = vendoring.ReplacePristine(dir, upstream); err != nil {
+		return err
+	}
+	record.Source, record.Version, record.VendoredAt = source, version, time.Now().UTC()
+	if len(cf.mptfDirs) > 0 {
+		record.SetMptfDirs(dir, cf.mptfDirs)
+	}
+	if err = record.Save(dir); err != nil {
+		return err
+	}
+	conflicts := 0
+	for _, r := range results {
+		_, _ = fmt.Fprintln(out, r.String())
+		if r.Status == vendoring.MergeConflict {
+			conflicts++
+		}
+	}
+	rel, _ := filepath.Rel(root, dir)
+	if conflicts > 0 {
+		return fmt.Errorf("module %s has been upgraded to %s with conflicts in %d files, resolve the conflict markers in them, files removed on one side and changed on the other are kept as they are", key, moduleVersionString(source, version), conflicts)
+	}
+	_, _ = fmt.Fprintf(out, "Module %s in %s has been upgraded to %s, run `terraform init` to install its new dependencies.\n", key, rel, moduleVersionString(source, version))
+	return nil
+}
+
+// vendoredModuleDir returns the dir of a vendored module, it's looked up in `modules.json` if not given.
+func vendoredModuleDir(root, key, dest string) (string, error) {
+	if dest == "" {
+		manifest, err := pkg.LoadModuleManifest(root)
+		if err != nil {
+			return "", err
+		}
+		dest = filepath.Join("vendor", key)
+		if manifest != nil {
+			if m := manifest.Module(key); m != nil && pkg.IsLocalModuleSource(m.Get("Source")) {
+				dest = filepath.FromSlash(m.Get("Dir"))
+			}
+		}
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(root, dest)
+	}
+	return dest, nil
+}
+
+func moduleVersionString(source, version string) string {
+	if version == "" {
+		return source
+	}
+	return fmt.Sprintf("%s %s", source, version)
+}
+
+func init() {
+	rootCmd.AddCommand(NewVendorCmd())
+}
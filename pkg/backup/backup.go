@@ -1,132 +1,509 @@
 package backup
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	filesystem "github.com/Azure/mapotf/pkg/fs"
 	"github.com/spf13/afero"
 )
 
-const BackupExtension = ".mptfbackup"
-const NewFileExtension = ".mptfnew"
+const ManifestFileName = "manifest.json"
 
-func BackupFolder(dir string) error {
-	terraformFile, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*.tf"))
-	if err != nil {
-		return fmt.Errorf("cannot list terraform files in %s:%+v", dir, err)
+// MaxHistory is the number of runs kept in the store, active runs are never removed.
+const MaxHistory = 10
+
+type RunStatus string
+
+const (
+	// RunActive runs have changed Terraform files that haven't been reverted or kept yet.
+	RunActive RunStatus = "active"
+	// RunReverted runs have been reverted by `Reset` or `Restore`, they're kept as history.
+	RunReverted RunStatus = "reverted"
+	// RunCleaned runs have been kept by `ClearBackup`, their file copies are removed, the manifests are kept as history.
+	RunCleaned RunStatus = "cleaned"
+)
+
+// Manifest describes a run, paths are relative to the root module's directory and use forward slashes.
+type Manifest struct {
+	RunId        string        `json:"run_id"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Status       RunStatus     `json:"status"`
+	Dirs         []string      `json:"dirs"`
+	Files        []BackupFile  `json:"files"`
+	CreatedFiles []CreatedFile `json:"created_files"`
+	Patches      []Patch       `json:"patches"`
+}
+
+// BackupFile is a file that existed before the run.
+type BackupFile struct {
+	Path              string      `json:"path"`
+	Sha256            string      `json:"sha256"`
+	Mode              os.FileMode `json:"mode"`
+	TransformedSha256 string      `json:"transformed_sha256"`
+}
+
+type CreatedFile struct {
+	Path              string `json:"path"`
+	TransformedSha256 string `json:"transformed_sha256"`
+}
+
+// Run is a set of backups stored in `.mapotf/backups/<run-id>/` under the root module's directory.
+type Run struct {
+	Manifest Manifest
+	root     string
+	dir      string
+}
+
+func StorePath(root string) string {
+	return filepath.Join(root, ".mapotf", "backups")
+}
+
+func NewRun(root string) (*Run, error) {
+	if err := pruneHistory(root); err != nil {
+		return nil, err
 	}
-	for _, file := range terraformFile {
-		backupFile := file + BackupExtension
-		exist, err := afero.Exists(filesystem.Fs, backupFile)
+	now := time.Now().UTC()
+	id := now.Format("20060102T150405.000000000Z")
+	dir := filepath.Join(StorePath(root), id)
+	for i := 1; ; i++ {
+		exist, err := afero.DirExists(filesystem.Fs, dir)
 		if err != nil {
-			return fmt.Errorf("cannot check backup file %s:%+v", backupFile, err)
+			return nil, fmt.Errorf("cannot check backup dir %s:%+v", dir, err)
+		}
+		if !exist {
+			break
 		}
-		if exist {
+		dir = filepath.Join(StorePath(root), fmt.Sprintf("%s-%d", id, i))
+	}
+	if err := filesystem.Fs.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create backup dir %s:%+v", dir, err)
+	}
+	r := &Run{
+		Manifest: Manifest{
+			RunId:        filepath.Base(dir),
+			CreatedAt:    now,
+			Status:       RunActive,
+			Dirs:         []string{},
+			Files:        []BackupFile{},
+			CreatedFiles: []CreatedFile{},
+			Patches:      []Patch{},
+		},
+		root: root,
+		dir:  dir,
+	}
+	return r, r.writeManifest()
+}
+
+// BackupFolder copies all Terraform files in the given directory into the run, along with their hashes and modes.
+func (r *Run) BackupFolder(dir string) error {
+	terraformFiles, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return fmt.Errorf("cannot list terraform files in %s:%+v", dir, err)
+	}
+	r.Manifest.Dirs = appendIfMissing(r.Manifest.Dirs, r.relPath(dir))
+	for _, file := range terraformFiles {
+		path := r.relPath(file)
+		if r.backupFile(path) != nil {
 			continue
 		}
-		// create the backup file, then copy the content of the terraform file to the backup file, with the same permission
 		content, err := afero.ReadFile(filesystem.Fs, file)
 		if err != nil {
 			return fmt.Errorf("cannot read terraform file %s:%+v", file, err)
 		}
-		// get permission of the terraform file
 		info, err := filesystem.Fs.Stat(file)
 		if err != nil {
 			return fmt.Errorf("cannot get permission of terraform file %s:%+v", file, err)
 		}
-		// write the content to the backup file
-		if err = afero.WriteFile(filesystem.Fs, backupFile, content, info.Mode()); err != nil {
+		backupFile := r.copyPath(path)
+		if err = filesystem.Fs.MkdirAll(filepath.Dir(backupFile), 0755); err != nil {
+			return fmt.Errorf("cannot create backup dir for %s:%+v", file, err)
+		}
+		if err = afero.WriteFile(filesystem.Fs, backupFile, content, info.Mode().Perm()); err != nil {
 			return fmt.Errorf("cannot write backup file %s:%+v", backupFile, err)
 		}
+		r.Manifest.Files = append(r.Manifest.Files, BackupFile{
+			Path:   path,
+			Sha256: Sha256(content),
+			Mode:   info.Mode().Perm(),
+		})
 	}
-	return nil
+	return r.writeManifest()
 }
 
-func Reset(dir string) error {
-	err := restoreBackup(dir)
+// Save records the created files and the hashes after the run, a run that has changed nothing is discarded.
+func (r *Run) Save() error {
+	r.Manifest.CreatedFiles = []CreatedFile{}
+	for _, dir := range r.Manifest.Dirs {
+		terraformFiles, err := afero.Glob(filesystem.Fs, filepath.Join(r.absPath(dir), "*.tf"))
+		if err != nil {
+			return fmt.Errorf("cannot list terraform files in %s:%+v", dir, err)
+		}
+		for _, file := range terraformFiles {
+			path := r.relPath(file)
+			if r.backupFile(path) != nil {
+				continue
+			}
+			hash, err := currentSha256(file)
+			if err != nil {
+				return err
+			}
+			r.Manifest.CreatedFiles = append(r.Manifest.CreatedFiles, CreatedFile{
+				Path:              path,
+				TransformedSha256: hash,
+			})
+		}
+	}
+	sort.Slice(r.Manifest.CreatedFiles, func(i, j int) bool {
+		return r.Manifest.CreatedFiles[i].Path < r.Manifest.CreatedFiles[j].Path
+	})
+	changed := len(r.Manifest.CreatedFiles) > 0
+	for i := range r.Manifest.Files {
+		f := &r.Manifest.Files[i]
+		hash, err := currentSha256(r.absPath(f.Path))
+		if err != nil {
+			return err
+		}
+		f.TransformedSha256 = hash
+		changed = changed || hash != f.Sha256
+	}
+	if !changed {
+		return r.Discard()
+	}
+	return r.writeManifest()
+}
+
+// currentSha256 returns the hash of the given file, or empty string if the file doesn't exist.
+func currentSha256(path string) (string, error) {
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
 	if err != nil {
+		return "", fmt.Errorf("cannot read terraform file %s:%+v", path, err)
+	}
+	return Sha256(content), nil
+}
+
+// Restore reverts the Terraform files changed by this run, files created by this run are removed.
+func (r *Run) Restore() error {
+	if r.Manifest.Status != RunActive {
+		return nil
+	}
+	exist, err := afero.DirExists(filesystem.Fs, r.dir)
+	if err != nil || !exist {
 		return err
 	}
-	return removeNewFiles(dir)
+	for _, f := range r.Manifest.CreatedFiles {
+		if err = filesystem.Fs.Remove(r.absPath(f.Path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot delete new file %s:%+v", f.Path, err)
+		}
+	}
+	for _, f := range r.Manifest.Files {
+		content, err := afero.ReadFile(filesystem.Fs, r.copyPath(f.Path))
+		if err != nil {
+			return fmt.Errorf("cannot read backup file for %s:%+v", f.Path, err)
+		}
+		if err = afero.WriteFile(filesystem.Fs, r.absPath(f.Path), content, f.Mode); err != nil {
+			return fmt.Errorf("cannot write original file %s:%+v", f.Path, err)
+		}
+		// The mode is only applied by WriteFile when the file is created.
+		if err = filesystem.Fs.Chmod(r.absPath(f.Path), f.Mode); err != nil {
+			return fmt.Errorf("cannot restore mode of original file %s:%+v", f.Path, err)
+		}
+	}
+	r.Manifest.Status = RunReverted
+	return r.writeManifest()
+}
+
+// Dir returns the directory of the run in the store.
+func (r *Run) Dir() string {
+	return r.dir
+}
+
+// Discard removes the run from the store without touching any Terraform file.
+func (r *Run) Discard() error {
+	if err := filesystem.Fs.RemoveAll(r.dir); err != nil {
+		return fmt.Errorf("cannot delete backup dir %s:%+v", r.dir, err)
+	}
+	return nil
 }
 
-func removeNewFiles(dir string) error {
-	newFileIndicators, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*"+NewFileExtension))
+// OriginalFile is a Terraform file before the oldest active run, Content is nil for created files.
+type OriginalFile struct {
+	Path    string
+	Content []byte
+	Created bool
+}
+
+// OriginalFiles returns all files changed or created by active runs, with their content before the oldest active run.
+func OriginalFiles(root string) ([]OriginalFile, error) {
+	runs, err := Runs(root)
 	if err != nil {
-		return fmt.Errorf("cannot list new file indicators in %s:%+v", dir, err)
+		return nil, err
 	}
-	for _, newFileIndicator := range newFileIndicators {
-		newFile, _ := strings.CutSuffix(newFileIndicator, NewFileExtension)
-		if err = filesystem.Fs.Remove(newFile); err != nil {
-			return fmt.Errorf("cannot delete new file %s:%+v", newFile, err)
+	var r []OriginalFile
+	seen := make(map[string]struct{})
+	for _, run := range runs {
+		if run.Manifest.Status != RunActive {
+			continue
+		}
+		for _, f := range run.Manifest.Files {
+			if _, ok := seen[f.Path]; ok {
+				continue
+			}
+			seen[f.Path] = struct{}{}
+			content, err := afero.ReadFile(filesystem.Fs, run.copyPath(f.Path))
+			if err != nil {
+				return nil, fmt.Errorf("cannot read backup file for %s:%+v", f.Path, err)
+			}
+			r = append(r, OriginalFile{
+				Path:    run.absPath(f.Path),
+				Content: content,
+			})
 		}
-		if err = filesystem.Fs.Remove(newFileIndicator); err != nil {
-			return fmt.Errorf("cannot delete new file indicator in %s:%+v", newFileIndicator, err)
+		for _, f := range run.Manifest.CreatedFiles {
+			if _, ok := seen[f.Path]; ok {
+				continue
+			}
+			seen[f.Path] = struct{}{}
+			r = append(r, OriginalFile{
+				Path:    run.absPath(f.Path),
+				Created: true,
+			})
 		}
 	}
-	return nil
+	return r, nil
+}
+
+// EditedFilesError is returned by Reset when files have been edited after mapotf changed them.
+type EditedFilesError struct {
+	Files []string
+}
+
+func (e *EditedFilesError) Error() string {
+	return fmt.Sprintf("following files have been edited since mapotf changed them, reset would overwrite these edits:\n  %s", strings.Join(e.Files, "\n  "))
 }
 
-func restoreBackup(dir string) error {
-	backupFiles, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*"+BackupExtension))
+// EditedFiles returns the files whose content differs from what the newest active run that touched them has written.
+func EditedFiles(root string) ([]string, error) {
+	runs, err := Runs(root)
 	if err != nil {
-		return fmt.Errorf("cannot list backup files in %s:%+v", dir, err)
+		return nil, err
 	}
-	for _, backupFile := range backupFiles {
-		// read the content of the backup file
-		content, err := afero.ReadFile(filesystem.Fs, backupFile)
+	var edited []string
+	seen := make(map[string]struct{})
+	check := func(run *Run, path, transformedSha256 string) error {
+		if _, ok := seen[path]; ok {
+			return nil
+		}
+		seen[path] = struct{}{}
+		// A run interrupted before Save has no hash recorded, its files are restored as they are.
+		if transformedSha256 == "" {
+			return nil
+		}
+		hash, err := currentSha256(run.absPath(path))
 		if err != nil {
-			return fmt.Errorf("cannot read backup file %s:%+v", backupFile, err)
+			return err
 		}
-		// write the content to the original file
-		originalFile := backupFile[:len(backupFile)-len(BackupExtension)] // remove the extension to get the original file name
-		info, err := getFilePerm(originalFile, backupFile, err)
+		if hash != transformedSha256 {
+			edited = append(edited, path)
+		}
+		return nil
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Manifest.Status != RunActive {
+			continue
+		}
+		for _, f := range run.Manifest.Files {
+			if err = check(run, f.Path, f.TransformedSha256); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range run.Manifest.CreatedFiles {
+			if err = check(run, f.Path, f.TransformedSha256); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(edited)
+	return edited, nil
+}
+
+// Reset reverts all active runs, unless force is true nothing is reverted if any file has been edited since.
+func Reset(root string, force bool) error {
+	if !force {
+		edited, err := EditedFiles(root)
 		if err != nil {
 			return err
 		}
-		if err = afero.WriteFile(filesystem.Fs, originalFile, content, info.Mode()); err != nil {
-			return fmt.Errorf("cannot write original file %s:%+v", originalFile, err)
+		if len(edited) > 0 {
+			return &EditedFilesError{Files: edited}
 		}
-		// delete the backup file
-		if err = filesystem.Fs.Remove(backupFile); err != nil {
-			return fmt.Errorf("cannot delete backup file %s:%+v", backupFile, err)
+	}
+	runs, err := Runs(root)
+	if err != nil {
+		return err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if err = runs[i].Restore(); err != nil {
+			return err
 		}
 	}
-	return nil
+	// The runs of an interrupted session have been reverted too.
+	return EndSession(root)
 }
 
-func ClearBackup(dir string) error {
-	backupFiles, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*"+BackupExtension))
+// ClearBackup keeps the changes made by all active runs, their file copies are removed but the manifests are kept.
+func ClearBackup(root string) error {
+	runs, err := Runs(root)
 	if err != nil {
-		return fmt.Errorf("cannot list backup files in %s:%+v", dir, err)
+		return err
 	}
-	newFileIndicators, err := afero.Glob(filesystem.Fs, filepath.Join(dir, "*"+NewFileExtension))
+	for _, r := range runs {
+		if r.Manifest.Status != RunActive {
+			continue
+		}
+		if err = filesystem.Fs.RemoveAll(filepath.Join(r.dir, "files")); err != nil {
+			return fmt.Errorf("cannot delete backup files of run %s:%+v", r.Manifest.RunId, err)
+		}
+		r.Manifest.Status = RunCleaned
+		if err = r.writeManifest(); err != nil {
+			return err
+		}
+	}
+	// The changes of an interrupted session are kept, there's nothing to recover anymore.
+	return EndSession(root)
+}
+
+// Runs returns all runs in the store, from the oldest to the newest.
+func Runs(root string) ([]*Run, error) {
+	store := StorePath(root)
+	exist, err := afero.DirExists(filesystem.Fs, store)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check backup store %s:%+v", store, err)
+	}
+	if !exist {
+		return nil, nil
+	}
+	entries, err := afero.ReadDir(filesystem.Fs, store)
 	if err != nil {
-		return fmt.Errorf("cannot list new file indicators in %s:%+v", dir, err)
+		return nil, fmt.Errorf("cannot list backup store %s:%+v", store, err)
 	}
-	files := append(backupFiles, newFileIndicators...)
-	for _, backupFile := range files {
-		// delete the backup file
-		if err = filesystem.Fs.Remove(backupFile); err != nil {
-			return fmt.Errorf("cannot delete backup file %s:%+v", backupFile, err)
+	var runs []*Run
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(store, e.Name())
+		content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, ManifestFileName))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read manifest in %s:%+v", dir, err)
+		}
+		r := &Run{
+			root: root,
+			dir:  dir,
 		}
+		if err = json.Unmarshal(content, &r.Manifest); err != nil {
+			return nil, fmt.Errorf("cannot parse manifest in %s:%+v", dir, err)
+		}
+		runs = append(runs, r)
 	}
-	return nil
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].Manifest.CreatedAt.Equal(runs[j].Manifest.CreatedAt) {
+			return runs[i].Manifest.CreatedAt.Before(runs[j].Manifest.CreatedAt)
+		}
+		return runs[i].Manifest.RunId < runs[j].Manifest.RunId
+	})
+	return runs, nil
 }
 
-func getFilePerm(originalFile string, backupFile string, err error) (os.FileInfo, error) {
-	var info os.FileInfo
-	for _, path := range []string{originalFile, backupFile} {
-		info, err = filesystem.Fs.Stat(path)
-		if err == nil {
+func pruneHistory(root string) error {
+	runs, err := Runs(root)
+	if err != nil {
+		return err
+	}
+	toRemove := len(runs) - MaxHistory + 1
+	for _, r := range runs {
+		if toRemove <= 0 {
 			break
 		}
+		if r.Manifest.Status == RunActive {
+			continue
+		}
+		if err = r.Discard(); err != nil {
+			return err
+		}
+		toRemove--
 	}
+	return nil
+}
+
+func (r *Run) writeManifest() error {
+	content, err := json.MarshalIndent(r.Manifest, "", "  ")
 	if err != nil {
-		return nil, fmt.Errorf("cannot get permission of backup file %s:%+v", backupFile, err)
+		return fmt.Errorf("cannot marshal manifest:%+v", err)
+	}
+	path := filepath.Join(r.dir, ManifestFileName)
+	if err = afero.WriteFile(filesystem.Fs, path, content, 0644); err != nil {
+		return fmt.Errorf("cannot write manifest %s:%+v", path, err)
+	}
+	return nil
+}
+
+func (r *Run) backupFile(path string) *BackupFile {
+	for i := range r.Manifest.Files {
+		if r.Manifest.Files[i].Path == path {
+			return &r.Manifest.Files[i]
+		}
 	}
-	return info, nil
+	return nil
+}
+
+func (r *Run) copyPath(path string) string {
+	return filepath.Join(r.dir, "files", filepath.FromSlash(path))
+}
+
+func (r *Run) relPath(path string) string {
+	if rel, err := filepath.Rel(r.root, path); err == nil && !filepath.IsAbs(rel) && rel != ".." && !hasParentPrefix(rel) {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+func (r *Run) absPath(path string) string {
+	p := filepath.FromSlash(path)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(r.root, p)
+}
+
+func hasParentPrefix(rel string) bool {
+	return len(rel) >= 3 && rel[:3] == ".."+string(filepath.Separator)
+}
+
+func appendIfMissing(s []string, v string) []string {
+	for _, e := range s {
+		if e == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
+
+func Sha256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
 }
@@ -1,140 +1,299 @@
 package backup
 
 import (
-	filesystem "github.com/Azure/mapotf/pkg/fs"
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
+	filesystem "github.com/Azure/mapotf/pkg/fs"
 	"github.com/prashantv/gostub"
 	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestMetaProgrammingTFPlan_OnlyTransformThatHasTargetShouldBeInThePlan(t *testing.T) {
-	dir := "cfg"
-	expectedContent := `resource "fake_resource" this {
-}`
+const originalContent = `resource "fake_resource" this {
+}
+`
+
+const transformedContent = `resource "fake_resource" this {
+  tags = {}
+}
+`
+
+func TestRun_BackupFolderShouldOnlyCopyTerraformFiles(t *testing.T) {
+	root := "/cfg"
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                expectedContent,
-		filepath.Join(dir, "non-terraform-file.txt"): "",
-		filepath.Join("etc", "terraform.tf"):         "should_not_be_copied",
+		filepath.Join(root, "main.tf"):                originalContent,
+		filepath.Join(root, "non-terraform-file.txt"): "",
+		filepath.Join("/etc", "terraform.tf"):         "should_not_be_copied",
 	}))
 	defer stub.Reset()
-	err := BackupFolder(dir)
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "main.tf"+BackupExtension))
+	require.NoError(t, run.BackupFolder(root))
+
+	require.Len(t, run.Manifest.Files, 1)
+	assert.Equal(t, "main.tf", run.Manifest.Files[0].Path)
+	assert.Equal(t, Sha256([]byte(originalContent)), run.Manifest.Files[0].Sha256)
+	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(StorePath(root), run.Manifest.RunId, "files", "main.tf"))
 	require.NoError(t, err)
-	assert.Equal(t, expectedContent, string(content))
-	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "non-terraform-file.txt"+BackupExtension))
+	assert.Equal(t, originalContent, string(content))
+	exists, err := afero.Exists(filesystem.Fs, filepath.Join(root, "main.tf"+BackupExtension))
 	require.NoError(t, err)
 	assert.False(t, exists)
-	exists, err = afero.Exists(filesystem.Fs, filepath.Join("etc", "terraform.tf"+BackupExtension))
+}
+
+func TestRun_SaveShouldRecordCreatedFiles(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		filepath.Join(root, "main.tf"): originalContent,
+	}))
+	defer stub.Reset()
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	assert.False(t, exists)
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, filepath.Join(root, "locals.tf"), []byte("locals {}"), 0644)
+	require.NoError(t, run.Save())
+
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, RunActive, runs[0].Manifest.Status)
+	assert.Equal(t, []CreatedFile{
+		{
+			Path:              "locals.tf",
+			TransformedSha256: Sha256([]byte("locals {}")),
+		},
+	}, runs[0].Manifest.CreatedFiles)
 }
 
-func TestBackupFolder_BackupFileAlreadyExists(t *testing.T) {
-	dir := "cfg"
-	originalContent := `resource "fake_resource" this {
-}`
-	backupContent := `resource "fake_resource" this {
-} backup`
+func TestRun_SaveShouldDiscardRunWithoutChanges(t *testing.T) {
+	root := "/cfg"
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                 originalContent,
-		filepath.Join(dir, "main.tf"+BackupExtension): backupContent,
+		filepath.Join(root, "main.tf"): originalContent,
 	}))
 	defer stub.Reset()
-	err := BackupFolder(dir)
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "main.tf"+BackupExtension))
+	require.NoError(t, run.BackupFolder(root))
+	require.NoError(t, run.Save())
+
+	runs, err := Runs(root)
 	require.NoError(t, err)
-	assert.Equal(t, backupContent, string(content))
+	assert.Empty(t, runs)
 }
 
-func TestRestoreBackup(t *testing.T) {
-	dir := "cfg"
-	originalContent := `resource "fake_resource" this {
-}`
-	backupContent := `resource "fake_resource" this {
-} backup`
+func TestReset_ShouldRevertAllActiveRuns(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	localsTf := filepath.Join(root, "locals.tf")
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                 originalContent,
-		filepath.Join(dir, "main.tf"+BackupExtension): backupContent,
+		mainTf: originalContent,
 	}))
 	defer stub.Reset()
-	err := Reset(dir)
+
+	first, err := NewRun(root)
 	require.NoError(t, err)
-	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	require.NoError(t, first.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	_ = afero.WriteFile(filesystem.Fs, localsTf, []byte("locals {}"), 0644)
+	require.NoError(t, first.Save())
+	second, err := NewRun(root)
 	require.NoError(t, err)
-	assert.Equal(t, backupContent, string(content))
-	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"+BackupExtension))
+	require.NoError(t, second.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, localsTf, []byte("locals {\n  a = 1\n}"), 0644)
+	require.NoError(t, second.Save())
+
+	require.NoError(t, Reset(root, false))
+
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, originalContent, string(content))
+	exists, err := afero.Exists(filesystem.Fs, localsTf)
 	require.NoError(t, err)
 	assert.False(t, exists)
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	for _, r := range runs {
+		assert.Equal(t, RunReverted, r.Manifest.Status)
+	}
 }
 
-func TestClearBackup_NewFileShouldBeRemoved(t *testing.T) {
-	dir := "cfg"
-	newFileContent := `resource "new_fake_resource" this {
-}`
+func TestRun_RestoreShouldOnlyRevertItsOwnChanges(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                  newFileContent,
-		filepath.Join(dir, "main.tf"+NewFileExtension): "",
+		mainTf: originalContent,
 	}))
 	defer stub.Reset()
-	err := ClearBackup(dir)
+
+	first, err := NewRun(root)
 	require.NoError(t, err)
-	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"+NewFileExtension))
+	require.NoError(t, first.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	require.NoError(t, first.Save())
+	second, err := NewRun(root)
 	require.NoError(t, err)
-	assert.False(t, exists)
-	exists, err = afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	require.NoError(t, second.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte("ephemeral"), 0644)
+	require.NoError(t, second.Save())
+
+	require.NoError(t, second.Restore())
+
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
 	require.NoError(t, err)
-	assert.True(t, exists)
-	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	assert.Equal(t, transformedContent, string(content))
+}
+
+func TestRun_RestoreShouldRestoreFileMode(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, afero.NewOsFs())
+	defer stub.Reset()
+	root := t.TempDir()
+	mainTf := filepath.Join(root, "main.tf")
+	require.NoError(t, os.WriteFile(mainTf, []byte(originalContent), 0664))
+	require.NoError(t, os.Chmod(mainTf, 0664))
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	assert.Equal(t, newFileContent, string(content))
+	require.NoError(t, run.BackupFolder(root))
+	require.NoError(t, os.WriteFile(mainTf, []byte(transformedContent), 0644))
+	require.NoError(t, os.Chmod(mainTf, 0644))
+	require.NoError(t, run.Save())
+	require.NoError(t, run.Restore())
+
+	info, err := os.Stat(mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0664), info.Mode().Perm())
 }
 
-func TestReset_NewFileShouldBeRemoved(t *testing.T) {
-	dir := "cfg"
-	newFileContent := `resource "new_fake_resource" this {
-}`
+func TestClearBackup_ShouldKeepManifest(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                  newFileContent,
-		filepath.Join(dir, "main.tf"+NewFileExtension): "",
+		mainTf: originalContent,
 	}))
 	defer stub.Reset()
-	err := Reset(dir)
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"+NewFileExtension))
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	require.NoError(t, run.Save())
+
+	require.NoError(t, ClearBackup(root))
+
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
 	require.NoError(t, err)
-	assert.False(t, exists)
-	exists, err = afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	assert.Equal(t, transformedContent, string(content))
+	exists, err := afero.Exists(filesystem.Fs, filepath.Join(StorePath(root), run.Manifest.RunId, "files"))
 	require.NoError(t, err)
 	assert.False(t, exists)
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, RunCleaned, runs[0].Manifest.Status)
 }
 
-func fakeFs(files map[string]string) afero.Fs {
-	fs := afero.NewMemMapFs()
-	for n, content := range files {
-		_ = afero.WriteFile(fs, n, []byte(content), 0644)
+func TestNewRun_ShouldPruneInactiveRuns(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: originalContent,
+	}))
+	defer stub.Reset()
+
+	for i := 0; i < MaxHistory+2; i++ {
+		run, err := NewRun(root)
+		require.NoError(t, err)
+		require.NoError(t, run.BackupFolder(root))
+		_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(fmt.Sprintf("# %d", i)), 0644)
+		require.NoError(t, run.Save())
+		require.NoError(t, run.Restore())
 	}
-	return fs
+
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	assert.Len(t, runs, MaxHistory)
 }
 
-func TestClearBackup(t *testing.T) {
-	dir := "cfg"
+func TestReset_ShouldRefuseEditedFiles(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	localsTf := filepath.Join(root, "locals.tf")
 	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
-		filepath.Join(dir, "main.tf"):                 "terraform content",
-		filepath.Join(dir, "main.tf"+BackupExtension): "backupContent",
+		mainTf: originalContent,
 	}))
 	defer stub.Reset()
-	err := ClearBackup(dir)
+
+	run, err := NewRun(root)
 	require.NoError(t, err)
-	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"+BackupExtension))
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	_ = afero.WriteFile(filesystem.Fs, localsTf, []byte("locals {}"), 0644)
+	require.NoError(t, run.Save())
+	edited := transformedContent + "# edited by hand\n"
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(edited), 0644)
+
+	err = Reset(root, false)
+	var editedErr *EditedFilesError
+	require.ErrorAs(t, err, &editedErr)
+	assert.Equal(t, []string{"main.tf"}, editedErr.Files)
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
 	require.NoError(t, err)
-	assert.False(t, exists)
-	exists, err = afero.Exists(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	assert.Equal(t, edited, string(content))
+
+	require.NoError(t, Reset(root, true))
+	content, err = afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, originalContent, string(content))
+}
+
+func TestReset_InterruptedRunWithoutRecordedHashShouldRestore(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: originalContent,
+	}))
+	defer stub.Reset()
+
+	run, err := NewRun(root)
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	require.Empty(t, run.Manifest.Files[0].TransformedSha256)
+
+	edited, err := EditedFiles(root)
+	require.NoError(t, err)
+	assert.Empty(t, edited)
+	require.NoError(t, Reset(root, false))
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, originalContent, string(content))
+}
+
+func TestEditedFiles_ShouldCompareWithNewestRun(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: originalContent,
+	}))
+	defer stub.Reset()
+
+	for _, content := range []string{transformedContent, transformedContent + "# second run\n"} {
+		run, err := NewRun(root)
+		require.NoError(t, err)
+		require.NoError(t, run.BackupFolder(root))
+		_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(content), 0644)
+		require.NoError(t, run.Save())
+	}
+
+	edited, err := EditedFiles(root)
 	require.NoError(t, err)
-	assert.True(t, exists)
+	assert.Empty(t, edited)
 }
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/spf13/afero"
+)
+
+// LockInfo is the content of the lock file, it tells who is holding the lock.
+type LockInfo struct {
+	Pid       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Hostname  string    `json:"hostname"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("PID %d on %s, since %s: %s", i.Pid, i.Hostname, i.CreatedAt.Local().Format(time.RFC3339), i.Command)
+}
+
+// Lock prevents mapotf processes from changing the same Terraform tree at the same time, it's held by commands that write
+// Terraform files or backups, from the first backup to the last restore.
+type Lock struct {
+	Info LockInfo
+	path string
+}
+
+// LockedError is returned by AcquireLock when the lock is held by another process, or left by a process that has crashed.
+type LockedError struct {
+	Path string
+	Info LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by another mapotf process (%s)", e.Path, e.Info.String())
+}
+
+func LockPath(root string) string {
+	return filepath.Join(root, ".mapotf", "lock")
+}
+
+// AcquireLock creates the lock file under the root module's directory, it fails with LockedError if the lock file exists.
+func AcquireLock(root, command string) (*Lock, error) {
+	path := LockPath(root)
+	if err := filesystem.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create dir for lock file %s:%+v", path, err)
+	}
+	hostname, _ := os.Hostname()
+	info := LockInfo{
+		Pid:       os.Getpid(),
+		Command:   command,
+		Hostname:  hostname,
+		CreatedAt: time.Now().UTC(),
+	}
+	content, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal lock info:%+v", err)
+	}
+	f, err := filesystem.Fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("cannot create lock file %s:%+v", path, err)
+		}
+		holder, readErr := ReadLock(root)
+		if readErr != nil {
+			return nil, readErr
+		}
+		return nil, &LockedError{Path: root, Info: *holder}
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = filesystem.Fs.Remove(path)
+		return nil, fmt.Errorf("cannot write lock file %s:%+v", path, err)
+	}
+	return &Lock{
+		Info: info,
+		path: path,
+	}, nil
+}
+
+// Release removes the lock file, unless it has been force-unlocked and taken by another process since.
+func (l *Lock) Release() error {
+	content, err := afero.ReadFile(filesystem.Fs, l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read lock file %s:%+v", l.path, err)
+	}
+	var info LockInfo
+	if err = json.Unmarshal(content, &info); err == nil && (info.Pid != l.Info.Pid || !info.CreatedAt.Equal(l.Info.CreatedAt)) {
+		return nil
+	}
+	if err = filesystem.Fs.Remove(l.path); err != nil {
+		return fmt.Errorf("cannot remove lock file %s:%+v", l.path, err)
+	}
+	return nil
+}
+
+// ReadLock returns the current holder of the lock, or nil if there's no lock. A lock file that cannot be parsed is
+// reported with empty info, so it can still be force-unlocked.
+func ReadLock(root string) (*LockInfo, error) {
+	path := LockPath(root)
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lock file %s:%+v", path, err)
+	}
+	info := &LockInfo{}
+	_ = json.Unmarshal(content, info)
+	return info, nil
+}
+
+// ForceUnlock removes the lock file no matter who is holding it, and returns the removed lock's holder, or nil if there
+// was no lock.
+func ForceUnlock(root string) (*LockInfo, error) {
+	info, err := ReadLock(root)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	path := LockPath(root)
+	if err = filesystem.Fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot remove lock file %s:%+v", path, err)
+	}
+	return info, nil
+}
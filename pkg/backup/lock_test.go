@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"testing"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireLock_ShouldFailIfLocked(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, afero.NewMemMapFs())
+	defer stub.Reset()
+
+	lock, err := AcquireLock(root, "mapotf apply")
+	require.NoError(t, err)
+	_, err = AcquireLock(root, "mapotf transform")
+	var lockedErr *LockedError
+	require.ErrorAs(t, err, &lockedErr)
+	assert.Equal(t, "mapotf apply", lockedErr.Info.Command)
+	assert.Equal(t, lock.Info.Pid, lockedErr.Info.Pid)
+
+	require.NoError(t, lock.Release())
+	lock, err = AcquireLock(root, "mapotf transform")
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestForceUnlock_ShouldRemoveStaleLock(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, afero.NewMemMapFs())
+	defer stub.Reset()
+
+	stale, err := AcquireLock(root, "mapotf apply")
+	require.NoError(t, err)
+	info, err := ForceUnlock(root)
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, "mapotf apply", info.Command)
+
+	lock, err := AcquireLock(root, "mapotf transform")
+	require.NoError(t, err)
+	// The stale lock's holder must not remove the new lock.
+	require.NoError(t, stale.Release())
+	holder, err := ReadLock(root)
+	require.NoError(t, err)
+	require.NotNil(t, holder)
+	assert.Equal(t, "mapotf transform", holder.Command)
+	require.NoError(t, lock.Release())
+
+	info, err = ForceUnlock(root)
+	require.NoError(t, err)
+	assert.Nil(t, info)
+}
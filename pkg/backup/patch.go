@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+)
+
+const patchContextLines = 3
+
+// Patch is the change made by a single transform to a single file, it can be reverted as long as the lines it has
+// written, along with their context, are still there.
+type Patch struct {
+	Transform string `json:"transform"`
+	Source    string `json:"source"`
+	MptfDir   string `json:"mptf_dir"`
+	Path      string `json:"path"`
+	Created   bool   `json:"created"`
+	Hunks     []Hunk `json:"hunks"`
+	Reverted  bool   `json:"reverted"`
+}
+
+// Hunk replaces Before lines starting at BeforeStart with After lines starting at AfterStart, line numbers are 0-based
+// and lines keep their line breaks.
+type Hunk struct {
+	BeforeStart int      `json:"before_start"`
+	Before      []string `json:"before"`
+	AfterStart  int      `json:"after_start"`
+	After       []string `json:"after"`
+}
+
+func NewPatch(transform, source, mptfDir string, created bool, before, after []byte) Patch {
+	a, b := splitLines(before), splitLines(after)
+	p := Patch{
+		Transform: transform,
+		Source:    source,
+		MptfDir:   mptfDir,
+		Created:   created,
+		Hunks:     []Hunk{},
+	}
+	for _, group := range difflib.NewMatcher(a, b).GetGroupedOpCodes(patchContextLines) {
+		first, last := group[0], group[len(group)-1]
+		p.Hunks = append(p.Hunks, Hunk{
+			BeforeStart: first.I1,
+			Before:      a[first.I1:last.I2],
+			AfterStart:  first.J1,
+			After:       b[first.J1:last.J2],
+		})
+	}
+	return p
+}
+
+// AddPatch records a patch for a file in this run, the path is converted to be relative to the root module.
+func (r *Run) AddPatch(path string, p Patch) {
+	p.Path = r.relPath(path)
+	r.Manifest.Patches = append(r.Manifest.Patches, p)
+}
+
+// reverse reverts the hunks on the given lines, from the last hunk to the first one so the line numbers of the hunks that
+// haven't been reverted yet still make sense. Lines might have been moved by later changes, so each hunk is looked up
+// around its original position.
+func (p Patch) reverse(lines []string) ([]string, error) {
+	for i := len(p.Hunks) - 1; i >= 0; i-- {
+		h := p.Hunks[i]
+		start, ok := findLines(lines, h.After, h.AfterStart)
+		if !ok {
+			return nil, fmt.Errorf("lines written near line %d have been changed since", h.AfterStart+1)
+		}
+		reverted := append([]string{}, lines[:start]...)
+		reverted = append(reverted, h.Before...)
+		lines = append(reverted, lines[start+len(h.After):]...)
+	}
+	return lines, nil
+}
+
+// findLines returns the position of the wanted lines in lines that is the closest to the expected position.
+func findLines(lines, wanted []string, expected int) (int, bool) {
+	if len(wanted) == 0 {
+		return expected, expected <= len(lines)
+	}
+	for distance := 0; distance <= len(lines); distance++ {
+		for _, start := range []int{expected - distance, expected + distance} {
+			if start < 0 || start+len(wanted) > len(lines) {
+				continue
+			}
+			if equalLines(lines[start:start+len(wanted)], wanted) {
+				return start, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func equalLines(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// PatchConflictError is returned by RevertPatches when some patches cannot be reverted, nothing has been written.
+type PatchConflictError struct {
+	Conflicts []string
+}
+
+func (e *PatchConflictError) Error() string {
+	return fmt.Sprintf("cannot revert following changes, they've been changed by later transforms or by hand:\n  %s", strings.Join(e.Conflicts, "\n  "))
+}
+
+type patchRef struct {
+	run   *Run
+	index int
+}
+
+// RevertPatches reverts the patches of active runs that match the filter, from the newest to the oldest. All patches are
+// reverted in memory first, if any of them conflicts with the current content, nothing is written. It returns the
+// files that have been changed.
+func RevertPatches(root string, match func(Patch) bool) ([]string, error) {
+	runs, err := Runs(root)
+	if err != nil {
+		return nil, err
+	}
+	var refs []patchRef
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Manifest.Status != RunActive {
+			continue
+		}
+		for j := len(run.Manifest.Patches) - 1; j >= 0; j-- {
+			p := run.Manifest.Patches[j]
+			if !p.Reverted && match(p) {
+				refs = append(refs, patchRef{run: run, index: j})
+			}
+		}
+	}
+	contents := make(map[string][]string)
+	originalHashes := make(map[string]string)
+	created := make(map[string]bool)
+	var conflicts []string
+	for _, ref := range refs {
+		p := ref.run.Manifest.Patches[ref.index]
+		path := ref.run.absPath(p.Path)
+		lines, ok := contents[path]
+		if !ok {
+			content, err := afero.ReadFile(filesystem.Fs, path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("cannot read %s:%+v", p.Path, err)
+			}
+			if err != nil {
+				conflicts = append(conflicts, fmt.Sprintf("%s in %s: file has been removed", p.Transform, p.Path))
+				continue
+			}
+			lines = splitLines(content)
+			originalHashes[path] = Sha256(content)
+		}
+		reverted, err := p.reverse(lines)
+		if err != nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s in %s: %s", p.Transform, p.Path, err.Error()))
+			continue
+		}
+		contents[path] = reverted
+		created[path] = p.Created
+	}
+	if len(conflicts) > 0 {
+		return nil, &PatchConflictError{Conflicts: conflicts}
+	}
+	var changed []string
+	for path, lines := range contents {
+		newHash := ""
+		if created[path] && len(lines) == 0 {
+			if err = filesystem.Fs.Remove(path); err != nil {
+				return nil, fmt.Errorf("cannot delete %s:%+v", path, err)
+			}
+		} else {
+			content := []byte(strings.Join(lines, ""))
+			info, err := filesystem.Fs.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get permission of %s:%+v", path, err)
+			}
+			if err = afero.WriteFile(filesystem.Fs, path, content, info.Mode().Perm()); err != nil {
+				return nil, fmt.Errorf("cannot write %s:%+v", path, err)
+			}
+			newHash = Sha256(content)
+		}
+		updateTransformedSha256(runs, path, originalHashes[path], newHash)
+		changed = append(changed, path)
+	}
+	for _, ref := range refs {
+		ref.run.Manifest.Patches[ref.index].Reverted = true
+	}
+	for _, run := range runs {
+		if run.Manifest.Status != RunActive {
+			continue
+		}
+		if err = run.writeManifest(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// updateTransformedSha256 updates the hash recorded by the newest active run that has touched the file, so the reverted
+// patches are not considered as manual edits. Files that have been edited by hand before are left as they are.
+func updateTransformedSha256(runs []*Run, path, oldHash, newHash string) {
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Manifest.Status != RunActive {
+			continue
+		}
+		rel := run.relPath(path)
+		for j := range run.Manifest.Files {
+			if run.Manifest.Files[j].Path == rel {
+				if run.Manifest.Files[j].TransformedSha256 == oldHash {
+					run.Manifest.Files[j].TransformedSha256 = newHash
+				}
+				return
+			}
+		}
+		for j := range run.Manifest.CreatedFiles {
+			if run.Manifest.CreatedFiles[j].Path == rel {
+				if run.Manifest.CreatedFiles[j].TransformedSha256 == oldHash {
+					run.Manifest.CreatedFiles[j].TransformedSha256 = newHash
+				}
+				return
+			}
+		}
+	}
+}
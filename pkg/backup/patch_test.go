@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const twoResources = `resource "fake_resource" this {
+}
+
+resource "fake_resource" that {
+}
+`
+
+func TestPatch_ReverseShouldFindMovedLines(t *testing.T) {
+	after := `resource "fake_resource" this {
+}
+
+resource "fake_resource" that {
+  tags = {}
+}
+`
+	p := NewPatch("transform.update_in_place.that", "/mptf/main.mptf.hcl", "/mptf", false, []byte(twoResources), []byte(after))
+	require.Len(t, p.Hunks, 1)
+
+	moved := "locals {\n}\n\n" + after
+	reverted, err := p.reverse(splitLines([]byte(moved)))
+	require.NoError(t, err)
+	assert.Equal(t, "locals {\n}\n\n"+twoResources, strings.Join(reverted, ""))
+}
+
+func TestPatch_ReverseShouldFailIfLinesHaveBeenChanged(t *testing.T) {
+	after := `resource "fake_resource" this {
+  tags = {}
+}
+`
+	p := NewPatch("transform.update_in_place.this", "/mptf/main.mptf.hcl", "/mptf", false, []byte(originalContent), []byte(after))
+
+	_, err := p.reverse(splitLines([]byte(`resource "fake_resource" this {
+  tags = { env = "prod" }
+}
+`)))
+	require.Error(t, err)
+}
+
+func TestRevertPatches_ShouldOnlyRevertMatchedTransform(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	localsTf := filepath.Join(root, "locals.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: twoResources,
+	}))
+	defer stub.Reset()
+
+	thisTagged := `resource "fake_resource" this {
+  tags = {}
+}
+
+resource "fake_resource" that {
+}
+`
+	bothTagged := `resource "fake_resource" this {
+  tags = {}
+}
+
+resource "fake_resource" that {
+  tags = {}
+}
+`
+	run, err := NewRun(root)
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(bothTagged), 0644)
+	_ = afero.WriteFile(filesystem.Fs, localsTf, []byte("locals {}\n"), 0644)
+	run.AddPatch(mainTf, NewPatch("transform.update_in_place.this", "/mptf/main.mptf.hcl", "/mptf", false, []byte(twoResources), []byte(thisTagged)))
+	run.AddPatch(mainTf, NewPatch("transform.update_in_place.that", "/mptf/main.mptf.hcl", "/mptf", false, []byte(thisTagged), []byte(bothTagged)))
+	run.AddPatch(localsTf, NewPatch("transform.new_block.locals", "/other/main.mptf.hcl", "/other", true, nil, []byte("locals {}\n")))
+	require.NoError(t, run.Save())
+
+	changed, err := RevertPatches(root, func(p Patch) bool {
+		return p.Transform == "transform.update_in_place.this" || p.MptfDir == "/other"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{localsTf, mainTf}, changed)
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, `resource "fake_resource" this {
+}
+
+resource "fake_resource" that {
+  tags = {}
+}
+`, string(content))
+	exists, err := afero.Exists(filesystem.Fs, localsTf)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	edited, err := EditedFiles(root)
+	require.NoError(t, err)
+	assert.Empty(t, edited)
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.True(t, runs[0].Manifest.Patches[0].Reverted)
+	assert.False(t, runs[0].Manifest.Patches[1].Reverted)
+	assert.True(t, runs[0].Manifest.Patches[2].Reverted)
+}
+
+func TestRevertPatches_ShouldWriteNothingOnConflict(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	localsTf := filepath.Join(root, "locals.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: originalContent,
+	}))
+	defer stub.Reset()
+
+	run, err := NewRun(root)
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	_ = afero.WriteFile(filesystem.Fs, localsTf, []byte("locals {}\n"), 0644)
+	run.AddPatch(mainTf, NewPatch("transform.update_in_place.this", "/mptf/main.mptf.hcl", "/mptf", false, []byte(originalContent), []byte(transformedContent)))
+	run.AddPatch(localsTf, NewPatch("transform.new_block.locals", "/mptf/main.mptf.hcl", "/mptf", true, nil, []byte("locals {}\n")))
+	require.NoError(t, run.Save())
+	edited := `resource "fake_resource" this {
+  tags = { env = "prod" }
+}
+`
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(edited), 0644)
+
+	_, err = RevertPatches(root, func(p Patch) bool {
+		return p.MptfDir == "/mptf"
+	})
+	var conflictErr *PatchConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Len(t, conflictErr.Conflicts, 1)
+	assert.Contains(t, conflictErr.Conflicts[0], "transform.update_in_place.this in main.tf")
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, edited, string(content))
+	exists, err := afero.Exists(filesystem.Fs, localsTf)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
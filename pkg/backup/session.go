@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/spf13/afero"
+)
+
+// Session is the marker of a wrapped Terraform command, a marker left on disk means the session has been interrupted.
+type Session struct {
+	Pid       int       `json:"pid"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func SessionPath(root string) string {
+	return filepath.Join(root, ".mapotf", "session")
+}
+
+// StartSession writes the session marker, it refuses to overwrite the marker of an interrupted session.
+func StartSession(root, command string) error {
+	path := SessionPath(root)
+	exists, err := afero.Exists(filesystem.Fs, path)
+	if err != nil {
+		return fmt.Errorf("cannot read session marker %s:%+v", path, err)
+	}
+	if exists {
+		return fmt.Errorf("session marker %s exists, a previous mapotf session has been interrupted, run `mapotf reset` to restore its files first", path)
+	}
+	if err := filesystem.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create dir for session marker %s:%+v", path, err)
+	}
+	content, err := json.MarshalIndent(Session{
+		Pid:       os.Getpid(),
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal session:%+v", err)
+	}
+	if err = afero.WriteFile(filesystem.Fs, path, content, 0644); err != nil {
+		return fmt.Errorf("cannot write session marker %s:%+v", path, err)
+	}
+	return nil
+}
+
+// EndSession removes the session marker.
+func EndSession(root string) error {
+	path := SessionPath(root)
+	if err := filesystem.Fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove session marker %s:%+v", path, err)
+	}
+	return nil
+}
+
+// InterruptedSession returns the session left by a previous process, it must be called with the lock held.
+func InterruptedSession(root string) (*Session, error) {
+	path := SessionPath(root)
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read session marker %s:%+v", path, err)
+	}
+	s := &Session{}
+	_ = json.Unmarshal(content, s)
+	return s, nil
+}
+
+// RecoverSession restores the active runs created by the interrupted session, then removes the session marker.
+func RecoverSession(root string, s *Session) error {
+	runs, err := Runs(root)
+	if err != nil {
+		return err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Manifest.Status != RunActive || run.Manifest.CreatedAt.Before(s.StartedAt) {
+			continue
+		}
+		if err = run.Restore(); err != nil {
+			return err
+		}
+	}
+	return EndSession(root)
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverSession_ShouldOnlyRestoreRunsOfTheSession(t *testing.T) {
+	root := "/cfg"
+	mainTf := filepath.Join(root, "main.tf")
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		mainTf: originalContent,
+	}))
+	defer stub.Reset()
+
+	kept, err := NewRun(root)
+	require.NoError(t, err)
+	require.NoError(t, kept.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent), 0644)
+	require.NoError(t, kept.Save())
+
+	require.NoError(t, StartSession(root, "mapotf apply"))
+	run, err := NewRun(root)
+	require.NoError(t, err)
+	require.NoError(t, run.BackupFolder(root))
+	_ = afero.WriteFile(filesystem.Fs, mainTf, []byte(transformedContent+"# ephemeral\n"), 0644)
+	require.NoError(t, run.Save())
+
+	s, err := InterruptedSession(root)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.Equal(t, "mapotf apply", s.Command)
+	require.NoError(t, RecoverSession(root, s))
+
+	content, err := afero.ReadFile(filesystem.Fs, mainTf)
+	require.NoError(t, err)
+	assert.Equal(t, transformedContent, string(content))
+	s, err = InterruptedSession(root)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+	runs, err := Runs(root)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, RunActive, runs[0].Manifest.Status)
+	assert.Equal(t, RunReverted, runs[1].Manifest.Status)
+}
+
+func TestReset_ShouldEndInterruptedSession(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		filepath.Join(root, "main.tf"): originalContent,
+	}))
+	defer stub.Reset()
+
+	require.NoError(t, StartSession(root, "mapotf apply"))
+	require.NoError(t, Reset(root, false))
+	s, err := InterruptedSession(root)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
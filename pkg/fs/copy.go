@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// CopyDir copies the regular files under src into dst, entries whose path relative to src is skipped are not copied.
+func CopyDir(src, dst string, skip func(rel string) bool) error {
+	return afero.Walk(Fs, src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return Fs.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := afero.ReadFile(Fs, path)
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %+v", path, err)
+		}
+		if err = afero.WriteFile(Fs, target, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("cannot write %s: %+v", target, err)
+		}
+		return nil
+	})
+}
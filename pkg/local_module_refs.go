@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/spf13/afero"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// localModuleRefs returns the root module in tfDir along with the local modules it calls, directly or not, found by
+// walking `module` blocks whose sources are `./` or `../` paths. It works offline before `terraform init`, remote modules
+// are not installed yet so they're skipped. Keys are named like Terraform does in `modules.json`, e.g. `network.subnet`.
+func localModuleRefs(tfDir string, strict bool) ([]*TerraformModuleRef, error) {
+	root, err := NewTerraformRootModuleRef(tfDir)
+	if err != nil {
+		return nil, err
+	}
+	refs := []*TerraformModuleRef{root}
+	if err = walkLocalModules(root, []*TerraformModuleRef{root}, strict, &refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// walkLocalModules appends the local modules called by the last module in callStack to refs, depth first. A module
+// whose dir is in its call stack already would call itself endlessly, it's reported as a cycle.
+func walkLocalModules(parent *TerraformModuleRef, callStack []*TerraformModuleRef, strict bool, refs *[]*TerraformModuleRef) error {
+	exists, err := afero.DirExists(filesystem.Fs, parent.AbsDir)
+	if err != nil || !exists {
+		return err
+	}
+	m, err := terraform.LoadModule(parent.toTerraformPkgType())
+	if err != nil && !strict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot load module %s in %s to find the local modules it calls: %+v", moduleDisplayName(parent.Key), parent.AbsDir, err)
+	}
+	for _, b := range m.ModuleBlocks {
+		source, ok := localModuleSource(b)
+		if !ok || len(b.Labels) != 1 {
+			continue
+		}
+		key := b.Labels[0]
+		if parent.Key != "" {
+			key = parent.Key + "." + key
+		}
+		ref, err := NewTerraformModuleRef(filepath.Join(parent.Dir, filepath.FromSlash(source)), key, source, "")
+		if err != nil {
+			return err
+		}
+		for i, caller := range callStack {
+			if caller.AbsDir != ref.AbsDir {
+				continue
+			}
+			var calls []string
+			for _, c := range callStack[i:] {
+				calls = append(calls, moduleDisplayName(c.Key))
+			}
+			return fmt.Errorf("cyclic module calls: %s -> %s, which is module %s in %s", strings.Join(calls, " -> "), key, moduleDisplayName(caller.Key), ref.AbsDir)
+		}
+		*refs = append(*refs, ref)
+		if err = walkLocalModules(ref, append(callStack, ref), strict, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localModuleSource returns the source of the `module` block if it's a literal local path.
+func localModuleSource(b *terraform.RootBlock) (string, bool) {
+	attr, ok := b.Attributes["source"]
+	if !ok {
+		return "", false
+	}
+	v, diag := attr.Expr.Value(nil)
+	if diag.HasErrors() || !v.IsKnown() || v.IsNull() || v.Type() != cty.String {
+		return "", false
+	}
+	source := v.AsString()
+	return source, IsLocalModuleSource(source)
+}
+
+// IsLocalModuleSource tells whether the module source is a local path, which Terraform doesn't install.
+func IsLocalModuleSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+func moduleDisplayName(key string) string {
+	if key == "" {
+		return "root"
+	}
+	return key
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/spf13/afero"
+)
+
+// ModuleManifest is Terraform's `.terraform/modules/modules.json`. Modules are kept as generic maps, so fields unknown to
+// mapotf are written back as they are.
+type ModuleManifest struct {
+	Path    string
+	Modules []ManifestModule
+	fields  map[string]json.RawMessage
+}
+
+type ManifestModule map[string]any
+
+// Get returns the string field of the given name, or an empty string.
+func (m ManifestModule) Get(name string) string {
+	s, _ := m[name].(string)
+	return s
+}
+
+func ModuleManifestPath(tfDir string) string {
+	return filepath.Join(tfDir, ".terraform", "modules", "modules.json")
+}
+
+// LoadModuleManifest returns nil if there's no `modules.json` in the given Terraform directory.
+func LoadModuleManifest(tfDir string) (*ModuleManifest, error) {
+	path := ModuleManifestPath(tfDir)
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read `modules.json` at %s: %+v", path, err)
+	}
+	m := &ModuleManifest{Path: path}
+	if err = json.Unmarshal(content, &m.fields); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal `modules.json` at %s: %+v", path, err)
+	}
+	if err = json.Unmarshal(m.fields["Modules"], &m.Modules); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal modules in `modules.json` at %s: %+v", path, err)
+	}
+	return m, nil
+}
+
+// Module returns the module of the given key, or nil if there's no such module.
+func (m *ModuleManifest) Module(key string) ManifestModule {
+	for _, module := range m.Modules {
+		if module.Get("Key") == key {
+			return module
+		}
+	}
+	return nil
+}
+
+func (m *ModuleManifest) Save() error {
+	modules, err := json.Marshal(m.Modules)
+	if err != nil {
+		return fmt.Errorf("cannot marshal modules: %+v", err)
+	}
+	if m.fields == nil {
+		m.fields = make(map[string]json.RawMessage)
+	}
+	m.fields["Modules"] = modules
+	content, err := json.MarshalIndent(m.fields, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal `modules.json`: %+v", err)
+	}
+	if err = afero.WriteFile(filesystem.Fs, m.Path, content, 0644); err != nil {
+		return fmt.Errorf("cannot write `modules.json` at %s: %+v", m.Path, err)
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/spf13/afero"
+	"github.com/zclconf/go-cty/cty"
 )
 
 var _ golden.Config = &MetaProgrammingTFConfig{}
@@ -27,7 +28,13 @@ type MetaProgrammingTFConfig struct {
 }
 
 func NewMetaProgrammingTFConfig(m *TerraformModuleRef, varConfigDir *string, hclBlocks []*golden.HclBlock, cliFlagAssignedVars []golden.CliFlagAssignedVariables, ctx context.Context) (*MetaProgrammingTFConfig, error) {
-	module, err := terraform.LoadModule(m.toTerraformPkgType())
+	return NewStagedMetaProgrammingTFConfig(m, nil, varConfigDir, hclBlocks, cliFlagAssignedVars, ctx)
+}
+
+// NewStagedMetaProgrammingTFConfig is like NewMetaProgrammingTFConfig, but the module is loaded from and saved into the
+// stage, which is written to disk by its Commit.
+func NewStagedMetaProgrammingTFConfig(m *TerraformModuleRef, stage *terraform.Stage, varConfigDir *string, hclBlocks []*golden.HclBlock, cliFlagAssignedVars []golden.CliFlagAssignedVariables, ctx context.Context) (*MetaProgrammingTFConfig, error) {
+	module, err := terraform.LoadStagedModule(m.toTerraformPkgType(), stage)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +60,20 @@ func (c *MetaProgrammingTFConfig) Init(hclBlocks []*golden.HclBlock) error {
 	return golden.InitConfig(c, hclBlocks)
 }
 
+// EvalContext adds `mptf` to the base eval context, so configs can refer to facts about the run, e.g.
+// `mptf.terraform_version`, which is null if the Terraform binary cannot be found.
+func (c *MetaProgrammingTFConfig) EvalContext() *hcl.EvalContext {
+	ctx := c.BaseConfig.EvalContext()
+	version := cty.NullVal(cty.String)
+	if TerraformVersion != "" {
+		version = cty.StringVal(TerraformVersion)
+	}
+	ctx.Variables["mptf"] = cty.ObjectVal(map[string]cty.Value{
+		"terraform_version": version,
+	})
+	return ctx
+}
+
 func (c *MetaProgrammingTFConfig) ResourceBlocks() []*terraform.RootBlock {
 	return c.slice(c.resourceBlocks)
 }
@@ -120,18 +141,33 @@ func (c *MetaProgrammingTFConfig) SaveToDisk() error {
 	return c.module.SaveToDisk()
 }
 
+// UseWriteJournal shares the given journal with this config, so conflicting writes made by transforms from different
+// mptf dirs can be detected.
+func (c *MetaProgrammingTFConfig) UseWriteJournal(journal *terraform.WriteJournal) {
+	c.module.UseJournal(journal)
+}
+
+func (c *MetaProgrammingTFConfig) SetFormatMode(mode terraform.FormatMode) {
+	c.module.SetFormatMode(mode)
+}
+
 func ModuleRefs(tfDir string) ([]*TerraformModuleRef, error) {
+	return moduleRefs(tfDir, true)
+}
+
+// ModuleRefsSkippingBroken doesn't walk local modules that cannot be parsed, so commands restoring files work on them.
+func ModuleRefsSkippingBroken(tfDir string) ([]*TerraformModuleRef, error) {
+	return moduleRefs(tfDir, false)
+}
+
+func moduleRefs(tfDir string, strict bool) ([]*TerraformModuleRef, error) {
 	moduleManifest := filepath.Join(tfDir, ".terraform", "modules", "modules.json")
 	exist, err := afero.Exists(filesystem.Fs, moduleManifest)
 	if err != nil {
 		return nil, fmt.Errorf("cannot check `modules.json` at %s: %+v", moduleManifest, err)
 	}
 	if !exist {
-		mod, err := NewTerraformRootModuleRef(tfDir)
-		if err != nil {
-			return nil, err
-		}
-		return []*TerraformModuleRef{mod}, nil
+		return localModuleRefs(tfDir, strict)
 	}
 	var modules = struct {
 		Modules []*TerraformModuleRef `json:"Modules"`
@@ -144,6 +180,10 @@ func ModuleRefs(tfDir string) ([]*TerraformModuleRef, error) {
 		return nil, fmt.Errorf("cannot unmarshal `modules.json` at %s: %+v", moduleManifest, err)
 	}
 	for i, m := range modules.Modules {
+		// Dirs in `modules.json` are relative to the root module, not to the current dir.
+		if dir := filepath.FromSlash(m.Dir); !filepath.IsAbs(dir) {
+			m.Dir = filepath.Join(tfDir, dir)
+		}
 		if err := m.Load(); err != nil {
 			return nil, fmt.Errorf("cannot load info for %s: %+v", m.Dir, err)
 		}
@@ -53,10 +53,8 @@ func TestModulePathsWhenModulesJsonExists(t *testing.T) {
 	for _, ref := range refs {
 		paths = append(paths, ref.AbsDir)
 	}
-	pwd, err := os.Getwd()
-	require.NoError(t, err)
-	assert.Contains(t, paths, pwd)
-	assert.Contains(t, paths, filepath.Join(pwd, "module"))
+	assert.Contains(t, paths, string(filepath.Separator))
+	assert.Contains(t, paths, filepath.Join(string(filepath.Separator), "module"))
 }
 
 func TestModulePathsWhenModulesJsonDoesNotExist(t *testing.T) {
@@ -81,3 +79,128 @@ func fakeFs(files map[string]string) afero.Fs {
 	}
 	return fs
 }
+
+func TestMetaProgrammingTFConfig_TerraformVersionShouldBeExposedToConfigs(t *testing.T) {
+	cases := []struct {
+		desc            string
+		version         string
+		expectedMovedTo []string
+	}{
+		{
+			desc:            "version supports moved block",
+			version:         "1.9.5",
+			expectedMovedTo: []string{"resource.fake_resource.this"},
+		},
+		{
+			desc:    "unknown version",
+			version: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+				filepath.Join("terraform", "main.tf"): `resource "fake_resource" this {
+}`,
+				filepath.Join("mptf", "main.mptf.hcl"): `
+locals {
+  supports_moved = try(tonumber(split(".", mptf.terraform_version)[1]) >= 1, false)
+}
+
+transform "update_in_place" this {
+  for_each             = local.supports_moved ? ["resource.fake_resource.this"] : []
+  target_block_address = each.value
+}
+`,
+			})).Stub(&pkg.TerraformVersion, c.version)
+			defer stub.Reset()
+			hclBlocks, err := pkg.LoadMPTFHclBlocks(false, "mptf")
+			require.NoError(t, err)
+			cfg, err := pkg.NewMetaProgrammingTFConfig(&pkg.TerraformModuleRef{
+				Dir:    "terraform",
+				AbsDir: "terraform",
+			}, nil, hclBlocks, nil, context.TODO())
+			require.NoError(t, err)
+			plan, err := pkg.RunMetaProgrammingTFPlan(cfg)
+			require.NoError(t, err)
+			var targets []string
+			for _, transform := range plan.Transforms {
+				targets = append(targets, transform.(*pkg.UpdateInPlaceTransform).TargetBlockAddress)
+			}
+			assert.Equal(t, c.expectedMovedTo, targets)
+		})
+	}
+}
+
+func TestModuleRefsShouldDiscoverLocalModulesWithoutModulesJson(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		"/cfg/main.tf": `
+module "network" {
+  source = "./modules/network"
+}
+
+module "vnet" {
+  source  = "Azure/vnet/azurerm"
+  version = "1.0.0"
+}
+`,
+		"/cfg/modules/network/main.tf": `
+module "subnet" {
+  source   = "../subnet"
+  for_each = toset(["a", "b"])
+}
+`,
+		"/cfg/modules/subnet/main.tf": `resource "fake_resource" this {}`,
+	}))
+	defer stub.Reset()
+
+	refs, err := pkg.ModuleRefs("/cfg")
+	require.NoError(t, err)
+	var keys, dirs, sources []string
+	for _, ref := range refs {
+		keys = append(keys, ref.Key)
+		dirs = append(dirs, ref.AbsDir)
+		sources = append(sources, ref.Source)
+	}
+	assert.Equal(t, []string{"", "network", "network.subnet"}, keys)
+	assert.Equal(t, []string{"/cfg", "/cfg/modules/network", "/cfg/modules/subnet"}, dirs)
+	assert.Equal(t, []string{"", "./modules/network", "../subnet"}, sources)
+}
+
+func TestModuleRefsShouldDetectCyclicLocalModules(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		"/cfg/main.tf": `
+module "a" {
+  source = "./modules/a"
+}
+`,
+		"/cfg/modules/a/main.tf": `
+module "b" {
+  source = "../b"
+}
+`,
+		"/cfg/modules/b/main.tf": `
+module "a" {
+  source = "../a"
+}
+`,
+	}))
+	defer stub.Reset()
+
+	_, err := pkg.ModuleRefs("/cfg")
+	require.ErrorContains(t, err, "cyclic module calls: a -> a.b -> a.b.a, which is module a in /cfg/modules/a")
+}
+
+func TestModuleRefsShouldReportLocalModuleThatCannotBeParsed(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		"/cfg/main.tf": `
+module "network" {
+  source = "./modules/network"
+}
+`,
+		"/cfg/modules/network/main.tf": `resource "fake_resource" this {`,
+	}))
+	defer stub.Reset()
+
+	_, err := pkg.ModuleRefs("/cfg")
+	require.ErrorContains(t, err, "cannot load module network in /cfg/modules/network")
+}
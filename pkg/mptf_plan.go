@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"github.com/Azure/golden"
+	"github.com/Azure/mapotf/pkg/terraform"
 	"github.com/hashicorp/go-multierror"
 	"strings"
 )
@@ -46,10 +47,19 @@ func (m *MetaProgrammingTFPlan) Apply() error {
 	}
 
 	for _, t := range m.Transforms {
+		m.c.module.SetWriter(terraform.Writer{
+			Address: t.Address(),
+			Type:    t.Type(),
+			Source:  t.HclBlock().Range().Filename,
+		})
 		if applyErr := t.Apply(); applyErr != nil {
 			err = multierror.Append(err, applyErr)
 		}
 	}
+	m.c.module.SetWriter(terraform.Writer{})
+	for _, conflict := range m.c.module.Journal().Conflicts() {
+		err = multierror.Append(err, conflict)
+	}
 	if err != nil {
 		return fmt.Errorf("errors applying transforms: %+v", err)
 	}
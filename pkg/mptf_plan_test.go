@@ -49,3 +49,80 @@ transform "update_in_place" fake_resource2 {
 	assert.Len(t, plan.Transforms, 1)
 	assert.Equal(t, "resource.fake_resource.this", plan.Transforms[0].(*pkg.UpdateInPlaceTransform).TargetBlockAddress)
 }
+
+func TestMetaProgrammingTFPlan_ConflictingWritesShouldReturnError(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		filepath.Join("terraform", "main.tf"): `resource "fake_resource" this {
+}`,
+		filepath.Join("mptf", "main.mptf.hcl"): `transform "update_in_place" first {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = {}
+  }
+}
+
+transform "update_in_place" second {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    tags = null
+  }
+}
+`,
+	}))
+	defer stub.Reset()
+	hclBlocks, err := pkg.LoadMPTFHclBlocks(false, "mptf")
+	require.NoError(t, err)
+	cfg, err := pkg.NewMetaProgrammingTFConfig(&pkg.TerraformModuleRef{
+		Dir:    "terraform",
+		AbsDir: "terraform",
+	}, nil, hclBlocks, nil, context.TODO())
+	require.NoError(t, err)
+	plan, err := pkg.RunMetaProgrammingTFPlan(cfg)
+	require.NoError(t, err)
+	err = plan.Apply()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "transform.update_in_place.first")
+	assert.Contains(t, err.Error(), "transform.update_in_place.second")
+}
+
+func TestMetaProgrammingTFPlan_AppendingDifferentDynamicBlocksShouldNotConflict(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		filepath.Join("terraform", "main.tf"): `resource "fake_resource" this {
+}`,
+		filepath.Join("mptf", "main.mptf.hcl"): `transform "update_in_place" first {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    dynamic "foo" {
+      for_each = []
+      content {
+        name = "x"
+      }
+    }
+  }
+}
+
+transform "update_in_place" second {
+  target_block_address = "resource.fake_resource.this"
+  asraw {
+    dynamic "bar" {
+      for_each = []
+      content {
+        name = "x"
+      }
+    }
+  }
+}
+`,
+	}))
+	defer stub.Reset()
+	hclBlocks, err := pkg.LoadMPTFHclBlocks(false, "mptf")
+	require.NoError(t, err)
+	cfg, err := pkg.NewMetaProgrammingTFConfig(&pkg.TerraformModuleRef{
+		Dir:    "terraform",
+		AbsDir: "terraform",
+	}, nil, hclBlocks, nil, context.TODO())
+	require.NoError(t, err)
+	plan, err := pkg.RunMetaProgrammingTFPlan(cfg)
+	require.NoError(t, err)
+	require.NoError(t, plan.Apply())
+}
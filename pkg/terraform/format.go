@@ -0,0 +1,82 @@
+package terraform
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+type FormatMode string
+
+const (
+	// FormatTouched formats only the blocks modified or created by transforms, every other byte is kept as it was.
+	FormatTouched FormatMode = "touched"
+	// FormatAll formats the whole file.
+	FormatAll FormatMode = "all"
+	// FormatNone keeps the existing code as it is, only blocks created by transforms are formatted.
+	FormatNone FormatMode = "none"
+)
+
+func ParseFormatMode(mode string) (FormatMode, error) {
+	switch FormatMode(mode) {
+	case FormatTouched, FormatAll, FormatNone:
+		return FormatMode(mode), nil
+	}
+	return "", fmt.Errorf("invalid format mode %s, must be one of `touched`, `all` or `none`", mode)
+}
+
+func (m *Module) SetFormatMode(mode FormatMode) {
+	m.formatMode = mode
+}
+
+func (m *Module) formatFile(wf *hclwrite.File) []byte {
+	switch m.formatMode {
+	case FormatAll:
+		return hclwrite.Format(wf.Bytes())
+	case FormatNone:
+		return formatBlocks(wf, m.isCreatedBlock)
+	}
+	return formatBlocks(wf, m.isTouchedBlock)
+}
+
+func (m *Module) isCreatedBlock(b *hclwrite.Block) bool {
+	_, loaded := m.originalBlocks[b]
+	return !loaded
+}
+
+// isTouchedBlock returns true if the block has been created or modified since it was loaded.
+func (m *Module) isTouchedBlock(b *hclwrite.Block) bool {
+	original, loaded := m.originalBlocks[b]
+	return !loaded || !bytes.Equal(original, b.BuildTokens(nil).Bytes())
+}
+
+// formatBlocks formats the tokens of the given top level blocks only, the rest of the file is kept byte-for-byte.
+// Tokens built by a block share the same pointers with the tokens built by its file, so we can locate the block's span.
+func formatBlocks(wf *hclwrite.File, shouldFormat func(*hclwrite.Block) bool) []byte {
+	fileTokens := wf.BuildTokens(nil)
+	spans := make(map[*hclwrite.Token]int)
+	for _, b := range wf.Body().Blocks() {
+		if !shouldFormat(b) {
+			continue
+		}
+		blockTokens := b.BuildTokens(nil)
+		if len(blockTokens) == 0 {
+			continue
+		}
+		spans[blockTokens[0]] = len(blockTokens)
+	}
+	var r []byte
+	start := 0
+	for i := 0; i < len(fileTokens); i++ {
+		length, ok := spans[fileTokens[i]]
+		if !ok {
+			continue
+		}
+		r = append(r, fileTokens[start:i].Bytes()...)
+		r = append(r, hclwrite.Format(fileTokens[i:i+length].Bytes())...)
+		i += length - 1
+		start = i + 1
+	}
+	return append(r, fileTokens[start:].Bytes()...)
+}
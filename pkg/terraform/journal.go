@@ -0,0 +1,210 @@
+package terraform
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// WriteJournal records every attribute and nested block write made through a Block, keyed by file, block address and
+// path, so writes to the same place coming from different transforms can be reported instead of silently overridden.
+type WriteJournal struct {
+	lock        sync.Mutex
+	entries     map[string]WriteEntry
+	conflicts   []WriteConflict
+	applied     []AppliedTransform
+	fileChanges []FileChange
+	mptfDirs    map[string]string
+}
+
+type WriteAction string
+
+const (
+	ActionSetAttribute WriteAction = "set_attribute"
+	ActionAppendBlock  WriteAction = "append_block"
+	ActionNewBlock     WriteAction = "new_block"
+	// ActionRemoveBlock entries are recorded for tracing only, they don't participate in conflict detection.
+	ActionRemoveBlock WriteAction = "remove_block"
+)
+
+type WriteEntry struct {
+	File      string
+	ModuleKey string
+	Block     string
+	Path      string
+	Action    WriteAction
+	Writer    Writer
+}
+
+// Writer identifies the transform that made a write, Source is the mptf file that declared the transform.
+type Writer struct {
+	Address string
+	Type    string
+	Source  string
+}
+
+// AppliedTransform is a transform that has been applied to a module, whether it has written anything or not.
+type AppliedTransform struct {
+	Writer    Writer
+	ModuleKey string
+}
+
+func (w Writer) String() string {
+	if w.Source == "" {
+		return w.Address
+	}
+	return fmt.Sprintf("%s(%s)", w.Address, w.Source)
+}
+
+// FileChange is the content of a file before and after a single transform, formatted as it would be saved.
+type FileChange struct {
+	Writer    Writer
+	ModuleKey string
+	File      string
+	Before    []byte
+	After     []byte
+	Created   bool
+}
+
+type WriteConflict struct {
+	Previous WriteEntry
+	Current  WriteEntry
+}
+
+func (c WriteConflict) Error() string {
+	return fmt.Sprintf("conflicting writes to `%s` in %s %s: %s and %s", c.Current.Path, c.Current.Block, c.Current.File, c.Previous.Writer.String(), c.Current.Writer.String())
+}
+
+func NewWriteJournal() *WriteJournal {
+	return &WriteJournal{
+		entries:  make(map[string]WriteEntry),
+		mptfDirs: make(map[string]string),
+	}
+}
+
+func (j *WriteJournal) Record(entry WriteEntry) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	key := fmt.Sprintf("%s#%s#%s", entry.File, entry.Block, entry.Path)
+	previous, ok := j.entries[key]
+	if ok && previous.Writer != entry.Writer && previous.Action != ActionRemoveBlock && entry.Action != ActionRemoveBlock {
+		j.conflicts = append(j.conflicts, WriteConflict{
+			Previous: previous,
+			Current:  entry,
+		})
+	}
+	j.entries[key] = entry
+}
+
+// Begin records that the given transform is going to be applied to a module.
+func (j *WriteJournal) Begin(writer Writer, moduleKey string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.applied = append(j.applied, AppliedTransform{
+		Writer:    writer,
+		ModuleKey: moduleKey,
+	})
+}
+
+func (j *WriteJournal) AppliedTransforms() []AppliedTransform {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]AppliedTransform{}, j.applied...)
+}
+
+func (j *WriteJournal) RecordFileChange(change FileChange) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.fileChanges = append(j.fileChanges, change)
+}
+
+// FileChanges returns the file changes in the order the transforms have been applied.
+func (j *WriteJournal) FileChanges() []FileChange {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]FileChange{}, j.fileChanges...)
+}
+
+// SetMptfDir records the mptf dir that has been localized to the given local dir, e.g. a git url.
+func (j *WriteJournal) SetMptfDir(localDir, mptfDir string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.mptfDirs[filepath.Clean(localDir)] = mptfDir
+}
+
+// MptfDir returns the mptf dir that declared the transform in the given source file.
+func (j *WriteJournal) MptfDir(source string) string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	localDir := filepath.Dir(source)
+	if dir, ok := j.mptfDirs[localDir]; ok {
+		return dir
+	}
+	return localDir
+}
+
+// Entries returns all entries sorted by file, block and path.
+func (j *WriteJournal) Entries() []WriteEntry {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var r []WriteEntry
+	for _, e := range j.entries {
+		r = append(r, e)
+	}
+	sort.Slice(r, func(i, k int) bool {
+		if r[i].File != r[k].File {
+			return r[i].File < r[k].File
+		}
+		if r[i].Block != r[k].Block {
+			return r[i].Block < r[k].Block
+		}
+		return r[i].Path < r[k].Path
+	})
+	return r
+}
+
+// Writers returns all transforms that have written to the given block.
+func (j *WriteJournal) Writers(file, block string) []Writer {
+	return j.writers(func(e WriteEntry) bool {
+		return e.File == file && e.Block == block
+	})
+}
+
+// FileWriters returns all transforms that have written to the given file.
+func (j *WriteJournal) FileWriters(file string) []Writer {
+	return j.writers(func(e WriteEntry) bool {
+		return e.File == file
+	})
+}
+
+func (j *WriteJournal) writers(filter func(WriteEntry) bool) []Writer {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var r []Writer
+	seen := make(map[Writer]struct{})
+	for _, e := range j.entries {
+		if !filter(e) {
+			continue
+		}
+		if _, ok := seen[e.Writer]; ok {
+			continue
+		}
+		seen[e.Writer] = struct{}{}
+		r = append(r, e.Writer)
+	}
+	sort.Slice(r, func(i, k int) bool {
+		return r[i].String() < r[k].String()
+	})
+	return r
+}
+
+// Conflicts returns the conflicts recorded since the last call, so a journal shared by multiple plans reports each
+// conflict only once.
+func (j *WriteJournal) Conflicts() []WriteConflict {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	r := j.conflicts
+	j.conflicts = nil
+	return r
+}
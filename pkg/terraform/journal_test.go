@@ -0,0 +1,104 @@
+package terraform
+
+import (
+	"testing"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJournal_SameWriterShouldNotConflict(t *testing.T) {
+	j := NewWriteJournal()
+	w := Writer{Address: "transform.update_in_place.this", Source: "main.mptf.hcl"}
+	j.Record(WriteEntry{File: "/main.tf", Block: "resource.fake_resource.this", Path: "tags", Writer: w})
+	j.Record(WriteEntry{File: "/main.tf", Block: "resource.fake_resource.this", Path: "tags", Writer: w})
+	assert.Empty(t, j.Conflicts())
+	assert.Len(t, j.Entries(), 1)
+}
+
+func TestWriteJournal_DifferentWritersShouldConflict(t *testing.T) {
+	j := NewWriteJournal()
+	first := Writer{Address: "transform.update_in_place.first", Source: "mptf1/main.mptf.hcl"}
+	second := Writer{Address: "transform.update_in_place.second", Source: "mptf2/main.mptf.hcl"}
+	j.Record(WriteEntry{File: "/main.tf", Block: "resource.fake_resource.this", Path: "tags", Writer: first})
+	j.Record(WriteEntry{File: "/main.tf", Block: "resource.fake_resource.that", Path: "tags", Writer: second})
+	assert.Empty(t, j.Conflicts())
+	j.Record(WriteEntry{File: "/main.tf", Block: "resource.fake_resource.this", Path: "tags", Writer: second})
+	conflicts := j.Conflicts()
+	require.Len(t, conflicts, 1)
+	assert.Contains(t, conflicts[0].Error(), "transform.update_in_place.first(mptf1/main.mptf.hcl)")
+	assert.Contains(t, conflicts[0].Error(), "transform.update_in_place.second(mptf2/main.mptf.hcl)")
+	assert.Empty(t, j.Conflicts())
+}
+
+func TestModule_WritesShouldBeRecordedInJournal(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	_ = afero.WriteFile(mockFs, "/main.tf", []byte(`resource "fake_resource" this {
+  nested_block {
+  }
+  nested_block {
+    dynamic "second_block" {
+      for_each = [1]
+      content {
+      }
+    }
+  }
+}
+`), 0644)
+	m, err := LoadModule(TerraformModuleRef{
+		Dir:    ".",
+		AbsDir: "/",
+	})
+	require.NoError(t, err)
+	m.SetWriter(Writer{Address: "transform.update_in_place.this"})
+	rb := m.ResourceBlocks[0]
+	rb.SetAttributeRaw("tags", hclwrite.TokensForIdentifier("null"))
+	rb.AppendBlock(hclwrite.NewBlock("lifecycle", nil))
+	rb.NestedBlocks["nested_block"][1].NestedBlocks["second_block"][0].SetAttributeRaw("id", hclwrite.TokensForIdentifier("null"))
+	var paths []string
+	for _, e := range m.Journal().Entries() {
+		assert.Equal(t, "/main.tf", e.File)
+		assert.Equal(t, "resource.fake_resource.this", e.Block)
+		assert.Equal(t, "transform.update_in_place.this", e.Writer.Address)
+		paths = append(paths, e.Path)
+	}
+	assert.ElementsMatch(t, []string{"tags", "lifecycle", "nested_block[1]/second_block[0]/id"}, paths)
+}
+
+func TestModule_FileChangesShouldBeRecordedPerTransform(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	_ = afero.WriteFile(mockFs, "/main.tf", []byte(`resource "fake_resource" this {
+}
+`), 0644)
+	m, err := LoadModule(TerraformModuleRef{
+		Dir:    ".",
+		AbsDir: "/",
+	})
+	require.NoError(t, err)
+	first := Writer{Address: "transform.update_in_place.first"}
+	second := Writer{Address: "transform.update_in_place.second"}
+	m.SetWriter(first)
+	m.ResourceBlocks[0].SetAttributeRaw("tags", hclwrite.TokensForIdentifier("null"))
+	m.SetWriter(second)
+	m.ResourceBlocks[0].SetAttributeRaw("count", hclwrite.TokensForIdentifier("null"))
+	m.SetWriter(Writer{})
+
+	changes := m.Journal().FileChanges()
+	require.Len(t, changes, 2)
+	assert.Equal(t, first, changes[0].Writer)
+	assert.Equal(t, "/main.tf", changes[0].File)
+	assert.NotContains(t, string(changes[0].Before), "tags")
+	assert.Contains(t, string(changes[0].After), "tags")
+	assert.NotContains(t, string(changes[0].After), "count")
+	assert.Equal(t, second, changes[1].Writer)
+	assert.Equal(t, changes[0].After, changes[1].Before)
+	assert.Contains(t, string(changes[1].After), "count")
+}
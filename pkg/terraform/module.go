@@ -1,13 +1,16 @@
 package terraform
 
 import (
-	"github.com/Azure/mapotf/pkg/backup"
+	"bytes"
 	"github.com/Azure/mapotf/pkg/fs"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/spf13/afero"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -28,7 +31,15 @@ type Module struct {
 	Dir            string
 	AbsDir         string
 	writeFiles     map[string]*hclwrite.File
+	originalFiles  map[string][]byte
+	fileModes      map[string]os.FileMode
+	formatMode     FormatMode
+	originalBlocks map[*hclwrite.Block][]byte
 	lock           *sync.Mutex
+	journal        *WriteJournal
+	stage          *Stage
+	writer         Writer
+	fileSnapshots  map[string][]byte
 	ResourceBlocks []*RootBlock
 	DataBlocks     []*RootBlock
 	ModuleBlocks   []*RootBlock
@@ -48,8 +59,12 @@ func (m *Module) loadConfig(cfg, filename string) error {
 		return diag
 	}
 	m.writeFiles[filename] = writeFile
+	m.originalFiles[filename] = []byte(cfg)
 	readBlocks := readFile.Body.(*hclsyntax.Body).Blocks
 	writeBlocks := writeFile.Body().Blocks()
+	for _, wb := range writeBlocks {
+		m.originalBlocks[wb] = wb.BuildTokens(nil).Bytes()
+	}
 	for i, rb := range readBlocks {
 		getter, want := wantedTypes[rb.Type]
 		if !want {
@@ -72,20 +87,33 @@ type TerraformModuleRef struct {
 }
 
 func LoadModule(mr TerraformModuleRef) (*Module, error) {
+	return LoadStagedModule(mr, nil)
+}
+
+// LoadStagedModule loads the module with the files saved into the stage by previously loaded modules, the module's
+// changes are saved into the stage too. The stage might be nil.
+func LoadStagedModule(mr TerraformModuleRef, stage *Stage) (*Module, error) {
 	files, err := afero.ReadDir(fs.Fs, mr.AbsDir)
 	if err != nil {
 		return nil, err
 	}
 	m := &Module{
-		Dir:        mr.Dir,
-		AbsDir:     mr.AbsDir,
-		writeFiles: make(map[string]*hclwrite.File),
-		lock:       &sync.Mutex{},
-		Key:        mr.Key,
-		Source:     mr.Source,
-		Version:    mr.Version,
-		GitHash:    mr.GitHash,
+		Dir:            mr.Dir,
+		AbsDir:         mr.AbsDir,
+		writeFiles:     make(map[string]*hclwrite.File),
+		originalFiles:  make(map[string][]byte),
+		fileModes:      make(map[string]os.FileMode),
+		formatMode:     FormatTouched,
+		originalBlocks: make(map[*hclwrite.Block][]byte),
+		lock:           &sync.Mutex{},
+		journal:        NewWriteJournal(),
+		stage:          stage,
+		Key:            mr.Key,
+		Source:         mr.Source,
+		Version:        mr.Version,
+		GitHash:        mr.GitHash,
 	}
+	var names []string
 	for _, f := range files {
 		if f.IsDir() {
 			continue
@@ -93,42 +121,161 @@ func LoadModule(mr TerraformModuleRef) (*Module, error) {
 		if !strings.HasSuffix(f.Name(), ".tf") {
 			continue
 		}
-		n := filepath.Join(mr.AbsDir, f.Name())
-		content, err := afero.ReadFile(fs.Fs, n)
-		if err != nil {
-			return nil, err
+		names = append(names, f.Name())
+		m.fileModes[f.Name()] = f.Mode().Perm()
+	}
+	if stage != nil {
+		names = append(names, stage.newFiles(mr.AbsDir)...)
+	}
+	for _, name := range names {
+		n := filepath.Join(mr.AbsDir, name)
+		content, staged := stage.read(n)
+		if !staged {
+			if content, err = afero.ReadFile(fs.Fs, n); err != nil {
+				return nil, err
+			}
 		}
-		if err = m.loadConfig(string(content), f.Name()); err != nil {
+		if err = m.loadConfig(string(content), name); err != nil {
 			return nil, err
 		}
 	}
-
-	return m, err
+	return m, nil
 }
 
+// SaveToDisk validates the generated code of all changed files, then writes them to temporary files and renames them
+// into place, or into the module's stage if it has one. Files that no transform has changed are left untouched. If any
+// step fails, the files that have been renamed are rolled back to their original content.
 func (m *Module) SaveToDisk() error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	for fn, wf := range m.writeFiles {
-		absPath := filepath.Join(m.Dir, fn)
-		exist, err := afero.Exists(fs.Fs, absPath)
-		if err != nil {
+	changes := m.changedFiles()
+	var err error
+	for _, c := range changes {
+		if validateErr := m.validateGeneratedFile(c.name, m.writeFiles[c.name], c.content); validateErr != nil {
+			err = multierror.Append(err, validateErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	stage := m.stage
+	if stage == nil {
+		stage = NewStage()
+	}
+	for _, c := range changes {
+		if err = stage.Put(filepath.Join(m.AbsDir, c.name), c.content, m.fileMode(c.name)); err != nil {
 			return err
 		}
-		if !exist {
-			absNewFilePath := absPath + backup.NewFileExtension
-			err = afero.WriteFile(fs.Fs, absNewFilePath, []byte{}, 0644)
-			if err != nil {
-				return err
-			}
+	}
+	if m.stage != nil {
+		return nil
+	}
+	return stage.Commit()
+}
+
+type fileChange struct {
+	name    string
+	content []byte
+}
+
+// changedFiles returns the formatted content of files whose bytes differ from what has been loaded from disk, files
+// created by transforms are always considered as changed.
+func (m *Module) changedFiles() []fileChange {
+	var r []fileChange
+	for fn, wf := range m.writeFiles {
+		original, loaded := m.originalFiles[fn]
+		// `hclwrite.File.Bytes` formats the tokens, so we compare the raw tokens instead.
+		if loaded && bytes.Equal(wf.BuildTokens(nil).Bytes(), original) {
+			continue
 		}
-		content := wf.Bytes()
-		err = afero.WriteFile(fs.Fs, absPath, hclwrite.Format(content), 0644)
-		if err != nil {
-			return err
+		r = append(r, fileChange{
+			name:    fn,
+			content: m.formatFile(wf),
+		})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].name < r[j].name
+	})
+	return r
+}
+
+func (m *Module) fileMode(filename string) os.FileMode {
+	if mode, ok := m.fileModes[filename]; ok {
+		return mode
+	}
+	return 0644
+}
+
+func (m *Module) Journal() *WriteJournal {
+	return m.journal
+}
+
+// UseJournal replaces the module's write journal, so writes made by plans from different mptf dirs can be checked against each other.
+func (m *Module) UseJournal(journal *WriteJournal) {
+	m.journal = journal
+}
+
+// SetWriter sets the transform that the following writes will be recorded for. The files changed by the previous
+// transform are recorded into the journal, so the changes made by each transform can be reverted separately.
+func (m *Module) SetWriter(writer Writer) {
+	m.recordFileChanges()
+	m.writer = writer
+	if m.journal == nil || writer.Address == "" {
+		m.fileSnapshots = nil
+		return
+	}
+	m.journal.Begin(writer, m.Key)
+	m.fileSnapshots = m.renderFiles()
+}
+
+func (m *Module) recordFileChanges() {
+	if m.journal == nil || m.writer.Address == "" || m.fileSnapshots == nil {
+		return
+	}
+	for fn, content := range m.renderFiles() {
+		before, existed := m.fileSnapshots[fn]
+		if existed && bytes.Equal(before, content) {
+			continue
 		}
+		m.journal.RecordFileChange(FileChange{
+			Writer:    m.writer,
+			ModuleKey: m.Key,
+			File:      filepath.Join(m.AbsDir, fn),
+			Before:    before,
+			After:     content,
+			Created:   !existed,
+		})
 	}
-	return nil
+}
+
+// renderFiles returns the content of all files as they would be saved.
+func (m *Module) renderFiles() map[string][]byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r := make(map[string][]byte)
+	for fn, wf := range m.writeFiles {
+		r[fn] = m.formatFile(wf)
+	}
+	return r
+}
+
+func (m *Module) recordWrite(filename, blockAddress, path string, action WriteAction) {
+	if m.journal == nil {
+		return
+	}
+	m.journal.Record(WriteEntry{
+		File:      filepath.Join(m.AbsDir, filename),
+		ModuleKey: m.Key,
+		Block:     blockAddress,
+		Path:      path,
+		Action:    action,
+		Writer:    m.writer,
+	})
+}
+
+// blockPath identifies a block by its type and labels, e.g. `dynamic.foo`.
+func blockPath(block *hclwrite.Block) string {
+	return strings.Join(append([]string{block.Type()}, block.Labels()...), ".")
 }
 
 func (m *Module) AddBlock(fileName string, block *hclwrite.Block) {
@@ -148,4 +295,5 @@ func (m *Module) AddBlock(fileName string, block *hclwrite.Block) {
 	}
 	writeFile.Body().AppendBlock(block)
 	writeFile.Body().AppendNewline()
+	m.recordWrite(fileName, blockPath(block), "", ActionNewBlock)
 }
@@ -1,10 +1,15 @@
 package terraform
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/prashantv/gostub"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -65,3 +70,272 @@ func TestModule_SaveToDisk(t *testing.T) {
 }`
 	assert.Equal(t, expectedContent, string(modifiedContent))
 }
+
+type failRenameFs struct {
+	afero.Fs
+	failOn string
+}
+
+func (f failRenameFs) Rename(oldname, newname string) error {
+	if newname == f.failOn {
+		return fmt.Errorf("rename %s failed", newname)
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+func TestModule_SaveToDiskShouldRollbackOnFailure(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	files := map[string]string{
+		"main.tf": `resource "fake_resource" "this" {
+}`,
+		"main2.tf": `resource "fake_resource" "that" {
+}`,
+	}
+	for n, content := range files {
+		_ = afero.WriteFile(mockFs, filepath.Join("tmp", n), []byte(content), 0644)
+	}
+	m, err := LoadModule(TerraformModuleRef{
+		Dir:    "tmp",
+		AbsDir: "tmp",
+	})
+	require.NoError(t, err)
+	for _, rb := range m.ResourceBlocks {
+		rb.WriteBlock.Body().SetAttributeValue("new_attribute", cty.StringVal("new_value"))
+	}
+	m.AddBlock("new.tf", hclwrite.NewBlock("locals", nil))
+	for _, failOn := range []string{"main.tf", "main2.tf", "new.tf"} {
+		stub.Stub(&filesystem.Fs, failRenameFs{Fs: mockFs, failOn: filepath.Join("tmp", failOn)})
+		err = m.SaveToDisk()
+		require.NotNil(t, err)
+		for n, content := range files {
+			actual, err := afero.ReadFile(mockFs, filepath.Join("tmp", n))
+			require.NoError(t, err)
+			assert.Equal(t, content, string(actual))
+		}
+		leftFiles, err := afero.ReadDir(mockFs, "tmp")
+		require.NoError(t, err)
+		assert.Len(t, leftFiles, 2)
+	}
+}
+
+func TestModule_SaveToDiskShouldRejectInvalidGeneratedCode(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	originalContent := `resource "fake_resource" "this" {
+}`
+	filename := filepath.Join("tmp", "main.tf")
+	_ = afero.WriteFile(mockFs, filename, []byte(originalContent), 0644)
+	m, err := LoadModule(TerraformModuleRef{
+		Dir:    "tmp",
+		AbsDir: "tmp",
+	})
+	require.NoError(t, err)
+	m.SetWriter(Writer{Address: "transform.update_in_place.broken", Source: "main.mptf.hcl"})
+	m.ResourceBlocks[0].SetAttributeRaw("tags", hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte("{")},
+		{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+	})
+	err = m.SaveToDisk()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "resource.fake_resource.this")
+	assert.Contains(t, err.Error(), "transform.update_in_place.broken(main.mptf.hcl)")
+	assert.Contains(t, err.Error(), "tags = {,")
+	content, err := afero.ReadFile(mockFs, filename)
+	require.NoError(t, err)
+	assert.Equal(t, originalContent, string(content))
+}
+
+func TestModule_SaveToDiskShouldOnlyWriteChangedFiles(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	untouchedContent := `resource "fake_resource" "that" {
+  id = 1
+  name = "untouched"
+}`
+	_ = afero.WriteFile(mockFs, filepath.Join("tmp", "main.tf"), []byte(`resource "fake_resource" "this" {
+}`), 0600)
+	_ = afero.WriteFile(mockFs, filepath.Join("tmp", "untouched.tf"), []byte(untouchedContent), 0644)
+	m, err := LoadModule(TerraformModuleRef{
+		Dir:    "tmp",
+		AbsDir: "tmp",
+	})
+	require.NoError(t, err)
+	for _, rb := range m.ResourceBlocks {
+		if rb.Address == "resource.fake_resource.this" {
+			rb.WriteBlock.Body().SetAttributeValue("new_attribute", cty.StringVal("new_value"))
+		}
+	}
+	untouchedPath := filepath.Join("tmp", "untouched.tf")
+	modTime := time.Now().Add(-time.Hour)
+	require.NoError(t, mockFs.Chtimes(untouchedPath, modTime, modTime))
+
+	err = m.SaveToDisk()
+	require.NoError(t, err)
+
+	info, err := mockFs.Stat(filepath.Join("tmp", "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = mockFs.Stat(untouchedPath)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(modTime))
+	content, err := afero.ReadFile(mockFs, untouchedPath)
+	require.NoError(t, err)
+	assert.Equal(t, untouchedContent, string(content))
+}
+
+func TestModule_SaveToDiskWithFormatMode(t *testing.T) {
+	originalContent := `resource "fake_resource" "this" {
+  id = 1
+  name = "this"
+}
+
+resource "fake_resource" "that" {
+  id = 1
+  name = "that"
+}
+`
+	cases := []struct {
+		mode     FormatMode
+		expected string
+	}{
+		{
+			mode: FormatTouched,
+			expected: `resource "fake_resource" "this" {
+  id            = 1
+  name          = "this"
+  new_attribute = "new_value"
+}
+
+resource "fake_resource" "that" {
+  id = 1
+  name = "that"
+}
+locals {
+  a = 1
+}
+
+`,
+		},
+		{
+			mode: FormatAll,
+			expected: `resource "fake_resource" "this" {
+  id            = 1
+  name          = "this"
+  new_attribute = "new_value"
+}
+
+resource "fake_resource" "that" {
+  id   = 1
+  name = "that"
+}
+locals {
+  a = 1
+}
+
+`,
+		},
+		{
+			mode: FormatNone,
+			expected: `resource "fake_resource" "this" {
+  id = 1
+  name = "this"
+new_attribute="new_value"
+}
+
+resource "fake_resource" "that" {
+  id = 1
+  name = "that"
+}
+locals {
+  a = 1
+}
+
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.mode), func(t *testing.T) {
+			mockFs := afero.NewMemMapFs()
+			stub := gostub.Stub(&filesystem.Fs, mockFs)
+			defer stub.Reset()
+			filename := filepath.Join("tmp", "main.tf")
+			_ = afero.WriteFile(mockFs, filename, []byte(originalContent), 0644)
+			m, err := LoadModule(TerraformModuleRef{
+				Dir:    "tmp",
+				AbsDir: "tmp",
+			})
+			require.NoError(t, err)
+			m.SetFormatMode(c.mode)
+			for _, rb := range m.ResourceBlocks {
+				if rb.Address == "resource.fake_resource.this" {
+					rb.SetAttributeRaw("new_attribute", hclwrite.TokensForValue(cty.StringVal("new_value")))
+				}
+			}
+			locals := hclwrite.NewBlock("locals", nil)
+			locals.Body().SetAttributeValue("a", cty.NumberIntVal(1))
+			m.AddBlock("main.tf", locals)
+			require.NoError(t, m.SaveToDisk())
+			content, err := afero.ReadFile(mockFs, filename)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, string(content))
+		})
+	}
+}
+
+func TestStage_CommitShouldRollbackAllModules(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	stub := gostub.Stub(&filesystem.Fs, mockFs)
+	defer stub.Reset()
+	original := "resource \"fake_resource\" \"this\" {\n}\n"
+	for _, dir := range []string{"/a", "/b"} {
+		_ = afero.WriteFile(mockFs, filepath.Join(dir, "main.tf"), []byte(original), 0644)
+	}
+	stage := NewStage()
+	for _, dir := range []string{"/a", "/b"} {
+		m, err := LoadStagedModule(TerraformModuleRef{Dir: dir, AbsDir: dir}, stage)
+		require.NoError(t, err)
+		m.ResourceBlocks[0].WriteBlock.Body().SetAttributeValue("new_attribute", cty.StringVal("new_value"))
+		require.NoError(t, m.SaveToDisk())
+	}
+	for _, dir := range []string{"/a", "/b"} {
+		content, err := afero.ReadFile(mockFs, filepath.Join(dir, "main.tf"))
+		require.NoError(t, err)
+		assert.Equal(t, original, string(content))
+	}
+	m, err := LoadStagedModule(TerraformModuleRef{Dir: "/b", AbsDir: "/b"}, stage)
+	require.NoError(t, err)
+	_, ok := m.ResourceBlocks[0].WriteBlock.Body().Attributes()["new_attribute"]
+	assert.True(t, ok)
+
+	stub.Stub(&filesystem.Fs, failRenameFs{Fs: mockFs, failOn: "/b/main.tf"})
+	require.Error(t, stage.Commit())
+	for _, dir := range []string{"/a", "/b"} {
+		content, err := afero.ReadFile(mockFs, filepath.Join(dir, "main.tf"))
+		require.NoError(t, err)
+		assert.Equal(t, original, string(content))
+		files, err := afero.ReadDir(mockFs, dir)
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	}
+}
+
+func TestModule_SaveToDiskShouldKeepFileModeOnOsFs(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, afero.NewOsFs())
+	defer stub.Reset()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	require.NoError(t, os.WriteFile(path, []byte("resource \"fake_resource\" \"this\" {\n}\n"), 0664))
+	// The umask might have stripped the group write bit on creation.
+	require.NoError(t, os.Chmod(path, 0664))
+	m, err := LoadModule(TerraformModuleRef{Dir: dir, AbsDir: dir})
+	require.NoError(t, err)
+	m.ResourceBlocks[0].WriteBlock.Body().SetAttributeValue("new_attribute", cty.StringVal("new_value"))
+	require.NoError(t, m.SaveToDisk())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0664), info.Mode().Perm())
+}
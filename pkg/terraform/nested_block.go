@@ -19,6 +19,8 @@ type NestedBlock struct {
 	ForEach        *Attribute
 	Attributes     map[string]*Attribute
 	NestedBlocks   NestedBlocks
+	root           *RootBlock
+	path           string
 }
 
 func (nb *NestedBlock) RemoveNestedBlock(path string) {
@@ -49,12 +51,21 @@ func (nb *NestedBlock) SetAttributeRaw(name string, tokens hclwrite.Tokens) {
 	unlock := lockBlockFile(nb)
 	defer unlock()
 	nb.WriteBody().SetAttributeRaw(name, tokens)
+	nb.recordWrite(name, ActionSetAttribute)
 }
 
 func (nb *NestedBlock) AppendBlock(block *hclwrite.Block) {
 	unlock := lockBlockFile(nb)
 	defer unlock()
 	nb.WriteBody().AppendBlock(block)
+	nb.recordWrite(blockPath(block), ActionAppendBlock)
+}
+
+func (nb *NestedBlock) recordWrite(name string, action WriteAction) {
+	if nb.root == nil {
+		return
+	}
+	nb.root.recordWrite(nb.path+"/"+name, action)
 }
 
 func (nb *NestedBlock) WriteBody() *hclwrite.Body {
@@ -70,10 +81,14 @@ func (nb *NestedBlock) GetNestedBlocks() NestedBlocks {
 }
 
 func NewNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block) *NestedBlock {
+	return newNestedBlock(rb, wb, nil, "")
+}
+
+func newNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block, root *RootBlock, path string) *NestedBlock {
 	if rb.Type == "dynamic" {
-		return dynamicNestedBlock(rb, wb)
+		return dynamicNestedBlock(rb, wb, root, path)
 	}
-	return staticNestedBlock(rb, wb)
+	return staticNestedBlock(rb, wb, root, path)
 }
 
 func (nb *NestedBlock) EvalContext() cty.Value {
@@ -99,7 +114,7 @@ func (nbs NestedBlocks) Values() map[string]cty.Value {
 	return v
 }
 
-func dynamicNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block) *NestedBlock {
+func dynamicNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block, root *RootBlock, path string) *NestedBlock {
 	return &NestedBlock{
 		Type:           rb.Labels[0],
 		selfWriteBlock: wb,
@@ -107,17 +122,21 @@ func dynamicNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block) *NestedBlock {
 		WriteBlock:     wb.Body().Blocks()[0],
 		ForEach:        NewAttribute("for_each", rb.Body.Attributes["for_each"], wb.Body().GetAttribute("for_each")),
 		Attributes:     attributes(rb.Body.Blocks[0].Body, wb.Body().Blocks()[0].Body()),
-		NestedBlocks:   nestedBlocks(rb.Body.Blocks[0].Body, wb.Body().Blocks()[0].Body()),
+		NestedBlocks:   nestedBlocks(rb.Body.Blocks[0].Body, wb.Body().Blocks()[0].Body(), root, path+"/"),
+		root:           root,
+		path:           path,
 	}
 }
 
-func staticNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block) *NestedBlock {
+func staticNestedBlock(rb *hclsyntax.Block, wb *hclwrite.Block, root *RootBlock, path string) *NestedBlock {
 	return &NestedBlock{
 		Type:           rb.Type,
 		Block:          rb,
 		selfWriteBlock: wb,
 		WriteBlock:     wb,
 		Attributes:     attributes(rb.Body, wb.Body()),
-		NestedBlocks:   nestedBlocks(rb.Body, wb.Body()),
+		NestedBlocks:   nestedBlocks(rb.Body, wb.Body(), root, path+"/"),
+		root:           root,
+		path:           path,
 	}
 }
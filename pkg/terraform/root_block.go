@@ -1,6 +1,7 @@
 package terraform
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 
@@ -73,6 +74,9 @@ func (b *RootBlock) RemoveNestedBlock(path string) {
 	if !ok {
 		return
 	}
+	if b.module != nil {
+		b.module.recordWrite(b.Range().Filename, b.Address, path, ActionRemoveBlock)
+	}
 	if len(segs) == 1 {
 		for _, nb := range nbs {
 			b.WriteBody().RemoveBlock(nb.selfWriteBlock)
@@ -88,12 +92,21 @@ func (b *RootBlock) SetAttributeRaw(name string, tokens hclwrite.Tokens) {
 	unlock := lockBlockFile(b)
 	defer unlock()
 	b.WriteBody().SetAttributeRaw(name, tokens)
+	b.recordWrite(name, ActionSetAttribute)
 }
 
 func (b *RootBlock) AppendBlock(block *hclwrite.Block) {
 	unlock := lockBlockFile(b)
 	defer unlock()
 	b.WriteBody().AppendBlock(block)
+	b.recordWrite(blockPath(block), ActionAppendBlock)
+}
+
+func (b *RootBlock) recordWrite(path string, action WriteAction) {
+	if b.module == nil {
+		return
+	}
+	b.module.recordWrite(b.Range().Filename, b.Address, path, action)
 }
 
 func (b *RootBlock) WriteBody() *hclwrite.Body {
@@ -124,7 +137,7 @@ func NewBlock(m *Module, rb *hclsyntax.Block, wb *hclwrite.Block) *RootBlock {
 		b.ForEach = NewAttribute("for_each", forEachAttr, wb.Body().GetAttribute("for_each"))
 	}
 	b.Attributes = attributes(rb.Body, wb.Body())
-	b.NestedBlocks = nestedBlocks(rb.Body, wb.Body())
+	b.NestedBlocks = nestedBlocks(rb.Body, wb.Body(), b, "")
 	return b
 }
 
@@ -155,11 +168,16 @@ func attributes(rb *hclsyntax.Body, wb *hclwrite.Body) map[string]*Attribute {
 	return r
 }
 
-func nestedBlocks(rb *hclsyntax.Body, wb *hclwrite.Body) NestedBlocks {
+func nestedBlocks(rb *hclsyntax.Body, wb *hclwrite.Body, root *RootBlock, parentPath string) NestedBlocks {
 	blocks := rb.Blocks
 	r := make(map[string][]*NestedBlock)
 	for i, block := range blocks {
-		nb := NewNestedBlock(block, wb.Blocks()[i])
+		nbType := block.Type
+		if nbType == "dynamic" {
+			nbType = block.Labels[0]
+		}
+		path := fmt.Sprintf("%s%s[%d]", parentPath, nbType, len(r[nbType]))
+		nb := newNestedBlock(block, wb.Blocks()[i], root, path)
 		r[nb.Type] = append(r[nb.Type], nb)
 	}
 	for _, v := range r {
@@ -0,0 +1,167 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Azure/mapotf/pkg/fs"
+	"github.com/spf13/afero"
+)
+
+const TempFileExtension = ".mptftmp"
+
+// Stage keeps the files saved by modules in memory, so files changed by many modules and mptf dirs are written together
+// by Commit, or not at all.
+type Stage struct {
+	lock  sync.Mutex
+	files map[string]*stagedFile
+}
+
+func NewStage() *Stage {
+	return &Stage{
+		files: make(map[string]*stagedFile),
+	}
+}
+
+type stagedFile struct {
+	path      string
+	tempPath  string
+	content   []byte
+	newFile   bool
+	original  []byte
+	mode      os.FileMode
+	committed bool
+}
+
+// Put stages the content of the file, the mode is used if the file doesn't exist yet.
+func (s *Stage) Put(path string, content []byte, mode os.FileMode) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sf, ok := s.files[path]; ok {
+		sf.content = content
+		return nil
+	}
+	original, err := afero.ReadFile(fs.Fs, path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read %s: %+v", path, err)
+	}
+	s.files[path] = &stagedFile{
+		path:     path,
+		tempPath: path + TempFileExtension,
+		content:  content,
+		newFile:  os.IsNotExist(err),
+		original: original,
+		mode:     mode,
+	}
+	return nil
+}
+
+// read returns the staged content of the file, or false if it's not staged.
+func (s *Stage) read(path string) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sf, ok := s.files[path]
+	if !ok {
+		return nil, false
+	}
+	return sf.content, true
+}
+
+// newFiles returns the names of the files staged in dir that don't exist on disk.
+func (s *Stage) newFiles(dir string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var r []string
+	for path, sf := range s.files {
+		if sf.newFile && filepath.Dir(path) == filepath.Clean(dir) {
+			r = append(r, filepath.Base(path))
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Commit writes all staged files to temporary files, then renames them into place. If any step fails, the files that
+// have been renamed are rolled back.
+func (s *Stage) Commit() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var staged []*stagedFile
+	for _, sf := range s.files {
+		staged = append(staged, sf)
+	}
+	sort.Slice(staged, func(i, j int) bool {
+		return staged[i].path < staged[j].path
+	})
+	for i, sf := range staged {
+		if err := writeTempFile(sf.tempPath, sf.content, sf.mode); err != nil {
+			discardStagedFiles(staged[:i])
+			return err
+		}
+	}
+	for i, sf := range staged {
+		if err := sf.commit(); err != nil {
+			rollbackStagedFiles(staged[:i+1])
+			discardStagedFiles(staged[i+1:])
+			return err
+		}
+	}
+	s.files = make(map[string]*stagedFile)
+	return nil
+}
+
+// writeTempFile writes the temp file with the exact mode, which is masked by the umask on creation.
+func writeTempFile(path string, content []byte, mode os.FileMode) error {
+	if err := afero.WriteFile(fs.Fs, path, content, mode); err != nil {
+		_ = fs.Fs.Remove(path)
+		return fmt.Errorf("cannot write temp file %s: %+v", path, err)
+	}
+	if err := fs.Fs.Chmod(path, mode); err != nil {
+		_ = fs.Fs.Remove(path)
+		return fmt.Errorf("cannot change mode of temp file %s: %+v", path, err)
+	}
+	return nil
+}
+
+func (sf *stagedFile) commit() error {
+	if err := fs.Fs.Rename(sf.tempPath, sf.path); err != nil {
+		return fmt.Errorf("cannot rename %s to %s: %+v", sf.tempPath, sf.path, err)
+	}
+	sf.committed = true
+	return nil
+}
+
+func (sf *stagedFile) rollback() {
+	_ = fs.Fs.Remove(sf.tempPath)
+	if !sf.committed {
+		return
+	}
+	if sf.newFile {
+		_ = fs.Fs.Remove(sf.path)
+		return
+	}
+	if err := writeTempFile(sf.tempPath, sf.original, sf.mode); err != nil {
+		return
+	}
+	if err := fs.Fs.Rename(sf.tempPath, sf.path); err != nil {
+		_ = fs.Fs.Remove(sf.tempPath)
+	}
+}
+
+func discardStagedFiles(staged []*stagedFile) {
+	for _, sf := range staged {
+		_ = fs.Fs.Remove(sf.tempPath)
+	}
+}
+
+func rollbackStagedFiles(staged []*stagedFile) {
+	for _, sf := range staged {
+		sf.rollback()
+	}
+}
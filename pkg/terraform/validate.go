@@ -0,0 +1,80 @@
+package terraform
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+const snippetContextLines = 2
+
+// validateGeneratedFile re-parses the generated content of a file, so invalid code produced by transforms is reported
+// before anything is written to disk. Each invalid block is reported along with the transforms that wrote to it.
+func (m *Module) validateGeneratedFile(filename string, wf *hclwrite.File, content []byte) error {
+	_, diag := hclsyntax.ParseConfig(content, filename, hcl.InitialPos)
+	if !diag.HasErrors() {
+		return nil
+	}
+	var err error
+	for _, b := range wf.Body().Blocks() {
+		blockContent := hclwrite.Format(b.BuildTokens(nil).Bytes())
+		_, blockDiag := hclsyntax.ParseConfig(blockContent, filename, hcl.InitialPos)
+		if !blockDiag.HasErrors() {
+			continue
+		}
+		address := strings.Join(append([]string{b.Type()}, b.Labels()...), ".")
+		err = multierror.Append(err, m.generatedCodeError(filename, address, blockContent, blockDiag))
+	}
+	if err == nil {
+		err = m.generatedCodeError(filename, "", content, diag)
+	}
+	return err
+}
+
+func (m *Module) generatedCodeError(filename, blockAddress string, content []byte, diag hcl.Diagnostics) error {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("generated code in %s is invalid", filename))
+	if blockAddress != "" {
+		sb.WriteString(fmt.Sprintf(", block %s", blockAddress))
+	}
+	var writers []string
+	if m.journal != nil && blockAddress != "" {
+		for _, w := range m.journal.Writers(filepath.Join(m.AbsDir, filename), blockAddress) {
+			writers = append(writers, w.String())
+		}
+	}
+	if len(writers) > 0 {
+		sb.WriteString(fmt.Sprintf(", written by %s", strings.Join(writers, ", ")))
+	}
+	for _, d := range diag {
+		if d.Severity != hcl.DiagError {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(":\n%s", d.Error()))
+		if d.Subject != nil {
+			sb.WriteString("\n")
+			sb.WriteString(snippet(content, d.Subject.Start.Line))
+		}
+	}
+	return fmt.Errorf("%s", sb.String())
+}
+
+func snippet(content []byte, line int) string {
+	lines := strings.Split(string(content), "\n")
+	start := max(line-snippetContextLines, 1)
+	end := min(line+snippetContextLines, len(lines))
+	sb := strings.Builder{}
+	for i := start; i <= end; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		sb.WriteString(fmt.Sprintf("%s %4d: %s\n", marker, i, lines[i-1]))
+	}
+	return sb.String()
+}
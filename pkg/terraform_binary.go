@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// TerraformBinaryEnv sets the Terraform binary, e.g. `tofu` or a path to a specific Terraform version, it's overridden
+// by `--tf-binary`.
+const TerraformBinaryEnv = "MPTF_TF_BINARY"
+
+// defaultTerraformBinaries are looked up in PATH in order when no binary is given.
+var defaultTerraformBinaries = []string{"terraform", "tofu"}
+
+var lookPath = exec.LookPath
+
+// FindTerraformBinary returns the given binary, or the one set by `MPTF_TF_BINARY`, or `terraform` or `tofu`, whichever
+// is found in PATH first.
+func FindTerraformBinary(binary string) (string, error) {
+	if binary == "" {
+		binary = os.Getenv(TerraformBinaryEnv)
+	}
+	if binary != "" {
+		return binary, nil
+	}
+	for _, b := range defaultTerraformBinaries {
+		if _, err := lookPath(b); err == nil {
+			return b, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find `terraform` or `tofu` in PATH, please set the binary by `--tf-binary` or `%s`", TerraformBinaryEnv)
+}
+
+// TerraformVersion is the version of the Terraform binary that will run the transformed code, it's exposed to configs as
+// `mptf.terraform_version`, and must be set before configs are created since locals are evaluated on creation.
+var TerraformVersion string
+
+// DetectTerraformVersion runs `version -json` with the given binary and returns its version, e.g. `1.9.5`. OpenTofu
+// reports its own version in the same field.
+var DetectTerraformVersion = func(binary string) (string, error) {
+	output, err := exec.Command(binary, "version", "-json").Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot get version of %s: %+v", binary, err)
+	}
+	var version struct {
+		TerraformVersion string `json:"terraform_version"`
+	}
+	if err = json.Unmarshal(output, &version); err != nil {
+		return "", fmt.Errorf("cannot parse version of %s: %+v", binary, err)
+	}
+	return version.TerraformVersion, nil
+}
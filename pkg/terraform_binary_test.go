@@ -0,0 +1,21 @@
+package pkg_test
+
+import (
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindTerraformBinary_FlagShouldOverrideEnv(t *testing.T) {
+	t.Setenv(pkg.TerraformBinaryEnv, "tofu")
+
+	binary, err := pkg.FindTerraformBinary("/opt/terraform/1.5.7/terraform")
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/terraform/1.5.7/terraform", binary)
+
+	binary, err = pkg.FindTerraformBinary("")
+	require.NoError(t, err)
+	assert.Equal(t, "tofu", binary)
+}
@@ -10,6 +10,9 @@ import (
 
 var AbsDir func(string) (string, error) = filepath.Abs
 
+// GitRoot returns the root of the git repository that contains the given path.
+var GitRoot func(string) (string, error) = gitRoot
+
 type TerraformModuleRef struct {
 	Key     string `json:"Key"`
 	Source  string `json:"Source"`
@@ -94,6 +97,17 @@ func gitHash(dir string) (string, error) {
 	return commit.Hash.String(), nil
 }
 
+func gitRoot(path string) (string, error) {
+	gitPath, err := lookupGitPath(path)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Base(gitPath) != ".git" {
+		return gitPath, nil
+	}
+	return filepath.Dir(gitPath), nil
+}
+
 func lookupGitPath(path string) (string, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
@@ -0,0 +1,300 @@
+package vendoring
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+)
+
+type MergeStatus string
+
+const (
+	// MergeUnchanged files are the same in the vendored module and the new version.
+	MergeUnchanged MergeStatus = "unchanged"
+	// MergeUpdated files haven't been edited locally, they're replaced by the new version.
+	MergeUpdated MergeStatus = "updated"
+	// MergeKept files have been edited locally and haven't changed in the new version, the local edits are kept.
+	MergeKept MergeStatus = "kept local edits"
+	// MergeMerged files have been changed both locally and in the new version, on different lines.
+	MergeMerged MergeStatus = "merged"
+	// MergeConflict files have been changed both locally and in the new version on the same lines, conflict markers are
+	// written for these lines, or the local file is kept as it is if it has been removed on one side.
+	MergeConflict MergeStatus = "conflict"
+)
+
+// FileMerge is the result of the three-way merge of a file in a vendored module, Conflicts is the number of conflicting
+// hunks.
+type FileMerge struct {
+	Path      string
+	Status    MergeStatus
+	Conflicts int
+}
+
+func (m FileMerge) String() string {
+	if m.Status == MergeConflict && m.Conflicts > 0 {
+		return fmt.Sprintf("%s: %s, %d conflicting hunks", m.Path, m.Status, m.Conflicts)
+	}
+	return fmt.Sprintf("%s: %s", m.Path, m.Status)
+}
+
+// Merge merges the changes from base to theirs into the vendored module in dir, so the local edits made since base are
+// kept. Files are merged line by line, lines changed on both sides are written with git style conflict markers.
+func Merge(dir, base, theirs, theirsLabel string) ([]FileMerge, error) {
+	paths := make(map[string]struct{})
+	for _, d := range []string{dir, base, theirs} {
+		files, err := listFiles(d)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			paths[f] = struct{}{}
+		}
+	}
+	var results []FileMerge
+	for path := range paths {
+		r, err := mergeFile(path, dir, base, theirs, theirsLabel)
+		if err != nil {
+			return nil, err
+		}
+		if r.Status != MergeUnchanged {
+			results = append(results, r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	return results, nil
+}
+
+func mergeFile(path, dir, base, theirs, theirsLabel string) (FileMerge, error) {
+	r := FileMerge{Path: path}
+	target := filepath.Join(dir, path)
+	oursContent, oursExist, err := readFile(target)
+	if err != nil {
+		return r, err
+	}
+	baseContent, baseExist, err := readFile(filepath.Join(base, path))
+	if err != nil {
+		return r, err
+	}
+	theirsContent, theirsExist, err := readFile(filepath.Join(theirs, path))
+	if err != nil {
+		return r, err
+	}
+	same := func(existA bool, a []byte, existB bool, b []byte) bool {
+		return existA == existB && bytes.Equal(a, b)
+	}
+	switch {
+	case same(oursExist, oursContent, theirsExist, theirsContent):
+		r.Status = MergeUnchanged
+		return r, nil
+	case same(oursExist, oursContent, baseExist, baseContent):
+		r.Status = MergeUpdated
+		if !theirsExist {
+			return r, removeFile(target)
+		}
+		return r, writeFile(target, filepath.Join(theirs, path), theirsContent)
+	case same(theirsExist, theirsContent, baseExist, baseContent):
+		r.Status = MergeKept
+		return r, nil
+	case !oursExist || !theirsExist:
+		// Removed on one side and changed on the other side, the local file is kept for the user to decide.
+		r.Status = MergeConflict
+		return r, nil
+	}
+	merged, conflicts := merge3(splitLines(baseContent), splitLines(oursContent), splitLines(theirsContent), theirsLabel)
+	r.Status, r.Conflicts = MergeMerged, conflicts
+	if conflicts > 0 {
+		r.Status = MergeConflict
+	}
+	return r, writeFile(target, target, []byte(strings.Join(merged, "")))
+}
+
+type hunk struct {
+	baseStart, baseEnd int
+	lines              []string
+	theirs             bool
+}
+
+const (
+	conflictOursMarker   = "<<<<<<< local"
+	conflictBaseMarker   = "||||||| vendored"
+	conflictSeparator    = "======="
+	conflictTheirsMarker = ">>>>>>> "
+)
+
+// merge3 merges the changes made from base to ours and from base to theirs, changes that overlap or touch each other are
+// merged only if they are the same. It returns the merged lines and the number of conflicts.
+func merge3(base, ours, theirs []string, theirsLabel string) ([]string, int) {
+	hunks := append(diffHunks(base, ours, false), diffHunks(base, theirs, true)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].baseStart < hunks[j].baseStart
+	})
+	var merged []string
+	conflicts := 0
+	pos := 0
+	for i := 0; i < len(hunks); {
+		start, end := hunks[i].baseStart, hunks[i].baseEnd
+		j := i + 1
+		for ; j < len(hunks) && hunks[j].baseStart <= end; j++ {
+			if hunks[j].baseEnd > end {
+				end = hunks[j].baseEnd
+			}
+		}
+		group := hunks[i:j]
+		i = j
+		merged = append(merged, base[pos:start]...)
+		pos = end
+		oursLines, oursChanged := applyHunks(base, group, start, end, false)
+		theirsLines, theirsChanged := applyHunks(base, group, start, end, true)
+		switch {
+		case !theirsChanged:
+			merged = append(merged, oursLines...)
+		case !oursChanged || equalLines(oursLines, theirsLines):
+			merged = append(merged, theirsLines...)
+		default:
+			conflicts++
+			merged = append(merged, conflictOursMarker+"\n")
+			merged = append(merged, withTrailingNewline(oursLines)...)
+			merged = append(merged, conflictBaseMarker+"\n")
+			merged = append(merged, withTrailingNewline(base[start:end])...)
+			merged = append(merged, conflictSeparator+"\n")
+			merged = append(merged, withTrailingNewline(theirsLines)...)
+			merged = append(merged, conflictTheirsMarker+theirsLabel+"\n")
+		}
+	}
+	merged = append(merged, base[pos:]...)
+	return merged, conflicts
+}
+
+func diffHunks(base, changed []string, theirs bool) []hunk {
+	var hunks []hunk
+	for _, op := range difflib.NewMatcher(base, changed).GetOpCodes() {
+		if op.Tag == 'e' {
+			continue
+		}
+		hunks = append(hunks, hunk{
+			baseStart: op.I1,
+			baseEnd:   op.I2,
+			lines:     changed[op.J1:op.J2],
+			theirs:    theirs,
+		})
+	}
+	return hunks
+}
+
+// applyHunks returns the lines of base between start and end with one side's hunks applied, and whether that side has
+// any hunk in this range.
+func applyHunks(base []string, group []hunk, start, end int, theirs bool) ([]string, bool) {
+	var lines []string
+	changed := false
+	pos := start
+	for _, h := range group {
+		if h.theirs != theirs {
+			continue
+		}
+		changed = true
+		lines = append(lines, base[pos:h.baseStart]...)
+		lines = append(lines, h.lines...)
+		pos = h.baseEnd
+	}
+	return append(lines, base[pos:end]...), changed
+}
+
+func withTrailingNewline(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	r := append([]string{}, lines...)
+	r[len(r)-1] += "\n"
+	return r
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// listFiles returns the paths of the regular files under dir relative to dir, the vendor state dir is excluded.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := afero.Walk(filesystem.Fs, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == StateDirName || info.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list files in %s:%+v", dir, err)
+	}
+	return files, nil
+}
+
+func readFile(path string) ([]byte, bool, error) {
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot read %s:%+v", path, err)
+	}
+	return content, true, nil
+}
+
+// writeFile writes content to path with the mode of modeFrom, or 0644 if modeFrom doesn't exist.
+func writeFile(path, modeFrom string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := filesystem.Fs.Stat(modeFrom); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := filesystem.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create dir for %s:%+v", path, err)
+	}
+	if err := afero.WriteFile(filesystem.Fs, path, content, mode); err != nil {
+		return fmt.Errorf("cannot write %s:%+v", path, err)
+	}
+	return nil
+}
+
+func removeFile(path string) error {
+	if err := filesystem.Fs.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove %s:%+v", path, err)
+	}
+	return nil
+}
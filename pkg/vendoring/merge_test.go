@@ -0,0 +1,124 @@
+package vendoring
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseVariables = `variable "name" {
+  type = string
+}
+
+variable "location" {
+  type = string
+}
+
+variable "tags" {
+  type = map(string)
+}
+`
+
+func TestMerge3(t *testing.T) {
+	cases := []struct {
+		desc              string
+		ours, theirs      string
+		expected          string
+		expectedConflicts int
+	}{
+		{
+			desc:   "changes on different lines",
+			ours:   strings.Replace(baseVariables, "name\" {\n  type = string", "name\" {\n  type = string\n  nullable = false", 1),
+			theirs: strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+			expected: `variable "name" {
+  type = string
+  nullable = false
+}
+
+variable "location" {
+  type = string
+}
+
+variable "tags" {
+  type = map(any)
+}
+`,
+		},
+		{
+			desc:     "same change on both sides",
+			ours:     strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+			theirs:   strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+			expected: strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+		},
+		{
+			desc:   "conflict",
+			ours:   strings.Replace(baseVariables, "map(string)", "map(number)", 1),
+			theirs: strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+			expected: strings.Replace(baseVariables, "  type = map(string)\n", `<<<<<<< local
+  type = map(number)
+||||||| vendored
+  type = map(string)
+=======
+  type = map(any)
+>>>>>>> v2
+`, 1),
+			expectedConflicts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			merged, conflicts := merge3(splitLines([]byte(baseVariables)), splitLines([]byte(c.ours)), splitLines([]byte(c.theirs)), "v2")
+			assert.Equal(t, c.expected, strings.Join(merged, ""))
+			assert.Equal(t, c.expectedConflicts, conflicts)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir, base, theirs := "/cfg/vendor/vnet", "/tmp/base", "/tmp/theirs"
+	files := map[string]string{
+		filepath.Join(base, "main.tf"):                  "resource \"fake_resource\" this {\n}\n",
+		filepath.Join(dir, "main.tf"):                   "resource \"fake_resource\" this {\n}\n",
+		filepath.Join(theirs, "main.tf"):                "resource \"fake_resource\" this {\n  tags = {}\n}\n",
+		filepath.Join(base, "variables.tf"):             baseVariables,
+		filepath.Join(dir, "variables.tf"):              strings.Replace(baseVariables, "map(string)", "map(number)", 1),
+		filepath.Join(theirs, "variables.tf"):           strings.Replace(baseVariables, "map(string)", "map(any)", 1),
+		filepath.Join(base, "outputs.tf"):               "output \"id\" {}\n",
+		filepath.Join(dir, "outputs.tf"):                "output \"id\" {\n  value = 1\n}\n",
+		filepath.Join(theirs, "outputs.tf"):             "output \"id\" {}\n",
+		filepath.Join(base, "removed.tf"):               "locals {}\n",
+		filepath.Join(dir, "removed.tf"):                "locals {}\n",
+		filepath.Join(theirs, "new.tf"):                 "locals {}\n",
+		filepath.Join(dir, StateDirName, "record.json"): "{}",
+	}
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(files))
+	defer stub.Reset()
+
+	results, err := Merge(dir, base, theirs, "v2")
+	require.NoError(t, err)
+	assert.Equal(t, []FileMerge{
+		{Path: "main.tf", Status: MergeUpdated},
+		{Path: "new.tf", Status: MergeUpdated},
+		{Path: "outputs.tf", Status: MergeKept},
+		{Path: "removed.tf", Status: MergeUpdated},
+		{Path: "variables.tf", Status: MergeConflict, Conflicts: 1},
+	}, results)
+	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, files[filepath.Join(theirs, "main.tf")], string(content))
+	content, err = afero.ReadFile(filesystem.Fs, filepath.Join(dir, "outputs.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, files[filepath.Join(dir, "outputs.tf")], string(content))
+	exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, "removed.tf"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	content, err = afero.ReadFile(filesystem.Fs, filepath.Join(dir, "variables.tf"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), ">>>>>>> v2")
+}
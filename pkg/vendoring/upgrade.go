@@ -0,0 +1,176 @@
+package vendoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/hashicorp/go-getter/v2"
+	"github.com/spf13/afero"
+)
+
+const defaultRegistryHost = "registry.terraform.io"
+
+// RegistryBaseUrl returns the base url of a module registry, it's a var so tests can serve a fake registry.
+var RegistryBaseUrl = func(host string) string {
+	return "https://" + host
+}
+
+// Fetch downloads the module into dst by go-getter and returns the dir of the module's files. Module registry sources
+// like `Azure/vnet/azurerm` are resolved into their download urls with the given version first, other sources are
+// passed to go-getter as they are, so local paths and `file://` urls work offline.
+func Fetch(ctx context.Context, source, version, dst string) (string, error) {
+	if abs, err := pkg.AbsDir(source); err == nil {
+		if exists, err := afero.DirExists(filesystem.Fs, abs); err == nil && exists {
+			source = abs
+		}
+	}
+	if address, subDir, ok := parseRegistrySource(source); ok {
+		if version == "" {
+			return "", fmt.Errorf("`--version` is required to fetch module %s from the registry", source)
+		}
+		downloadUrl, err := registryDownloadUrl(ctx, address, version)
+		if err != nil {
+			return "", err
+		}
+		source = withSubDir(downloadUrl, subDir)
+	}
+	result, err := getter.Get(ctx, dst, source)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch module %s:%+v", source, err)
+	}
+	// Local dirs are linked rather than copied by go-getter.
+	if resolved, err := filepath.EvalSymlinks(result.Dst); err == nil {
+		return resolved, nil
+	}
+	return result.Dst, nil
+}
+
+// registryAddress is a module registry source, e.g. `Azure/vnet/azurerm` or `app.terraform.io/org/vnet/azurerm`.
+type registryAddress struct {
+	host, namespace, name, provider string
+}
+
+func parseRegistrySource(source string) (registryAddress, string, bool) {
+	if pkg.IsLocalModuleSource(source) || filepath.IsAbs(source) || strings.Contains(source, "::") || strings.Contains(source, "://") {
+		return registryAddress{}, "", false
+	}
+	address, subDir, _ := strings.Cut(source, "//")
+	segs := strings.Split(address, "/")
+	host := defaultRegistryHost
+	if len(segs) == 4 {
+		host, segs = segs[0], segs[1:]
+	}
+	if len(segs) != 3 || strings.Contains(segs[0], ".") {
+		return registryAddress{}, "", false
+	}
+	for _, s := range segs {
+		if s == "" {
+			return registryAddress{}, "", false
+		}
+	}
+	return registryAddress{
+		host:      host,
+		namespace: segs[0],
+		name:      segs[1],
+		provider:  segs[2],
+	}, subDir, true
+}
+
+// registryDownloadUrl asks the registry where the module of the given version is, by the module registry protocol.
+func registryDownloadUrl(ctx context.Context, address registryAddress, version string) (string, error) {
+	base, err := url.Parse(RegistryBaseUrl(address.host) + "/")
+	if err != nil {
+		return "", err
+	}
+	discovery, err := base.Parse(".well-known/terraform.json")
+	if err != nil {
+		return "", err
+	}
+	resp, err := registryGet(ctx, discovery.String())
+	if err != nil {
+		return "", err
+	}
+	var services map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&services)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot decode service discovery of %s:%+v", address.host, err)
+	}
+	modulesPath, ok := services["modules.v1"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s doesn't serve modules", address.host)
+	}
+	modulesUrl, err := base.Parse(modulesPath)
+	if err != nil {
+		return "", err
+	}
+	downloadUrl, err := modulesUrl.Parse(strings.Join([]string{address.namespace, address.name, address.provider, version, "download"}, "/"))
+	if err != nil {
+		return "", err
+	}
+	resp, err = registryGet(ctx, downloadUrl.String())
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	location := resp.Header.Get("X-Terraform-Get")
+	if location == "" {
+		return "", fmt.Errorf("cannot find download url of module %s/%s/%s %s in %s", address.namespace, address.name, address.provider, version, address.host)
+	}
+	// The location might be relative to the download endpoint, go-getter's forced getters like `git::` are kept.
+	if strings.Contains(location, "::") {
+		return location, nil
+	}
+	resolved, err := downloadUrl.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse download url %s:%+v", location, err)
+	}
+	return resolved.String(), nil
+}
+
+func registryGet(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot request %s:%+v", u, err)
+	}
+	if resp.StatusCode >= 300 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("cannot request %s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// withSubDir adds the sub dir to a go-getter source, before the query string.
+func withSubDir(source, subDir string) string {
+	if subDir == "" {
+		return source
+	}
+	address, query, hasQuery := strings.Cut(source, "?")
+	address = address + "//" + subDir
+	if hasQuery {
+		address += "?" + query
+	}
+	return address
+}
+
+// ReplacePristine replaces the pristine copy of the vendored module in dir with the files in upstream.
+func ReplacePristine(dir, upstream string) error {
+	pristine := PristinePath(dir)
+	if err := filesystem.Fs.RemoveAll(pristine); err != nil {
+		return fmt.Errorf("cannot remove pristine copy %s:%+v", pristine, err)
+	}
+	return filesystem.CopyDir(upstream, pristine, func(rel string) bool {
+		return filepath.Base(rel) == ".git"
+	})
+}
@@ -0,0 +1,81 @@
+package vendoring
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prashantv/gostub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRegistrySource(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected registryAddress
+		subDir   string
+		registry bool
+	}{
+		{source: "Azure/vnet/azurerm", expected: registryAddress{host: defaultRegistryHost, namespace: "Azure", name: "vnet", provider: "azurerm"}, registry: true},
+		{source: "registry.terraform.io/Azure/vnet/azurerm//modules/subnet", expected: registryAddress{host: defaultRegistryHost, namespace: "Azure", name: "vnet", provider: "azurerm"}, subDir: "modules/subnet", registry: true},
+		{source: "app.terraform.io/org/vnet/azurerm", expected: registryAddress{host: "app.terraform.io", namespace: "org", name: "vnet", provider: "azurerm"}, registry: true},
+		{source: "github.com/Azure/terraform-azurerm-vnet"},
+		{source: "git::https://github.com/Azure/terraform-azurerm-vnet.git?ref=v1.0.0"},
+		{source: "file:///modules/vnet"},
+		{source: "./modules/vnet"},
+	}
+	for _, c := range cases {
+		t.Run(c.source, func(t *testing.T) {
+			address, subDir, ok := parseRegistrySource(c.source)
+			assert.Equal(t, c.registry, ok)
+			assert.Equal(t, c.expected, address)
+			assert.Equal(t, c.subDir, subDir)
+		})
+	}
+}
+
+func TestRegistryDownloadUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/terraform.json":
+			_, _ = w.Write([]byte(`{"modules.v1":"/api/modules/v1/"}`))
+		case "/api/modules/v1/Azure/vnet/azurerm/2.0.0/download":
+			w.Header().Set("X-Terraform-Get", "git::https://github.com/Azure/terraform-azurerm-vnet?ref=v2.0.0")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	stub := gostub.Stub(&RegistryBaseUrl, func(string) string {
+		return server.URL
+	})
+	defer stub.Reset()
+
+	address, subDir, ok := parseRegistrySource("Azure/vnet/azurerm//modules/subnet")
+	require.True(t, ok)
+	u, err := registryDownloadUrl(context.Background(), address, "2.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "git::https://github.com/Azure/terraform-azurerm-vnet//modules/subnet?ref=v2.0.0", withSubDir(u, subDir))
+
+	_, err = registryDownloadUrl(context.Background(), address, "3.0.0")
+	require.Error(t, err)
+}
+
+func TestFetch_LocalDirShouldWorkOffline(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "main.tf"), []byte(`resource "fake_resource" this {}`), 0644))
+
+	for _, source := range []string{src, "file://" + filepath.ToSlash(src)} {
+		dir, err := Fetch(context.Background(), source, "2.0.0", filepath.Join(t.TempDir(), "upstream"))
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(content), `resource "fake_resource"`))
+	}
+}
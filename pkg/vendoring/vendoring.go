@@ -0,0 +1,271 @@
+package vendoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/mapotf/pkg"
+	"github.com/Azure/mapotf/pkg/backup"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/Azure/mapotf/pkg/terraform"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/spf13/afero"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// StateDirName is the dir in a vendored module that records where the module comes from, along with its pristine copy.
+// Terraform ignores it, since only `.tf` files in the module's own dir are loaded.
+const StateDirName = ".mapotf-vendor"
+
+// Record tells where a vendored module comes from, so it can be vendored again from a newer version. Local mptf dirs
+// are relative to the vendored module's dir.
+type Record struct {
+	Key        string    `json:"key"`
+	Source     string    `json:"source"`
+	Version    string    `json:"version,omitempty"`
+	MptfDirs   []string  `json:"mptf_dirs,omitempty"`
+	VendoredAt time.Time `json:"vendored_at"`
+}
+
+func RecordPath(dir string) string {
+	return filepath.Join(dir, StateDirName, "record.json")
+}
+
+// PristinePath returns the dir that keeps the module's files as they were vendored, before any transform or edit.
+func PristinePath(dir string) string {
+	return filepath.Join(dir, StateDirName, "pristine")
+}
+
+// ReadRecord returns the record of the vendored module in the given dir, or nil if the module is not vendored.
+func ReadRecord(dir string) (*Record, error) {
+	path := RecordPath(dir)
+	content, err := afero.ReadFile(filesystem.Fs, path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vendor record %s:%+v", path, err)
+	}
+	r := &Record{}
+	if err = json.Unmarshal(content, r); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal vendor record %s:%+v", path, err)
+	}
+	return r, nil
+}
+
+func (r *Record) Save(dir string) error {
+	path := RecordPath(dir)
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal vendor record:%+v", err)
+	}
+	if err = filesystem.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create dir for vendor record %s:%+v", path, err)
+	}
+	if err = afero.WriteFile(filesystem.Fs, path, content, 0644); err != nil {
+		return fmt.Errorf("cannot write vendor record %s:%+v", path, err)
+	}
+	return nil
+}
+
+// SetMptfDirs records the mptf dirs applied to the vendored module in the given dir, local dirs are recorded relative to
+// the module's dir, so the record doesn't depend on where the repository is checked out.
+func (r *Record) SetMptfDirs(dir string, mptfDirs []string) {
+	r.MptfDirs = nil
+	for _, mptfDir := range mptfDirs {
+		if abs, err := pkg.AbsDir(mptfDir); err == nil {
+			if exists, err := afero.DirExists(filesystem.Fs, abs); err == nil && exists {
+				if rel, err := filepath.Rel(dir, abs); err == nil {
+					mptfDir = filepath.ToSlash(rel)
+				}
+			}
+		}
+		r.MptfDirs = append(r.MptfDirs, mptfDir)
+	}
+}
+
+// ResolveMptfDirs returns the recorded mptf dirs, local dirs are resolved against the vendored module's dir.
+func (r *Record) ResolveMptfDirs(dir string) []string {
+	var dirs []string
+	for _, mptfDir := range r.MptfDirs {
+		local := filepath.Join(dir, filepath.FromSlash(mptfDir))
+		if exists, err := afero.DirExists(filesystem.Fs, local); err == nil && exists {
+			mptfDir = local
+		}
+		dirs = append(dirs, mptfDir)
+	}
+	return dirs
+}
+
+// Vendor copies the installed module of the given key from `.terraform/modules` into dest, points the calling `module`
+// block and `modules.json` at the copy, and records the module's original source and version. The root module's
+// `modules.json` must exist, and the calling module must be the root module, a local module, or a vendored module.
+func Vendor(root, key, dest string) (*Record, error) {
+	manifest, err := pkg.LoadModuleManifest(root)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("cannot find `modules.json` in %s, run `terraform init` first", root)
+	}
+	module := manifest.Module(key)
+	if key == "" || module == nil {
+		return nil, fmt.Errorf("module %s is not found in %s", key, manifest.Path)
+	}
+	source, version := module.Get("Source"), module.Get("Version")
+	if pkg.IsLocalModuleSource(source) {
+		return nil, fmt.Errorf("module %s is a local module already, its source is %s", key, source)
+	}
+	parentKey, name := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		parentKey, name = key[:i], key[i+1:]
+	}
+	parent := manifest.Module(parentKey)
+	if parent == nil {
+		return nil, fmt.Errorf("module %s is not found in %s", parentKey, manifest.Path)
+	}
+	src := manifestDir(root, module.Get("Dir"))
+	parentDir := manifestDir(root, parent.Get("Dir"))
+	if isUnder(parentDir, filepath.Join(root, ".terraform")) {
+		return nil, fmt.Errorf("module %s is called by module %s, which is installed in `.terraform`, vendor module %s first", key, parentKey, parentKey)
+	}
+	if err = ensureNotTransformed(root, src); err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(root, dest)
+	}
+	if exists, err := afero.Exists(filesystem.Fs, dest); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%s exists already", dest)
+		}
+		return nil, err
+	}
+
+	skipGit := func(rel string) bool {
+		return filepath.Base(rel) == ".git"
+	}
+	if err = filesystem.CopyDir(src, dest, skipGit); err != nil {
+		return nil, err
+	}
+	if err = filesystem.CopyDir(src, PristinePath(dest), skipGit); err != nil {
+		return nil, err
+	}
+	vendoredSource, err := relativeSource(parentDir, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err = rewriteModuleBlock(parentDir, name, vendoredSource); err != nil {
+		return nil, err
+	}
+	if err = rebaseManifest(manifest, root, key, src, dest, vendoredSource); err != nil {
+		return nil, err
+	}
+	record := &Record{
+		Key:        key,
+		Source:     source,
+		Version:    version,
+		VendoredAt: time.Now().UTC(),
+	}
+	return record, record.Save(dest)
+}
+
+// ensureNotTransformed refuses to vendor a module that has been transformed and not reset, the pristine copy would
+// contain the transformed files otherwise.
+func ensureNotTransformed(root, dir string) error {
+	runs, err := backup.Runs(root)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if run.Manifest.Status != backup.RunActive {
+			continue
+		}
+		for _, d := range run.Manifest.Dirs {
+			if filepath.Join(root, filepath.FromSlash(d)) == dir {
+				return fmt.Errorf("%s has been transformed by run %s, run `mapotf reset` before vendoring it", dir, run.Manifest.RunId)
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteModuleBlock sets the source of the `module` block of the given name in dir, the version is removed since local
+// modules cannot have one.
+func rewriteModuleBlock(dir, name, source string) error {
+	m, err := terraform.LoadModule(terraform.TerraformModuleRef{
+		Dir:    dir,
+		AbsDir: dir,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot load module in %s:%+v", dir, err)
+	}
+	for _, b := range m.ModuleBlocks {
+		if len(b.Labels) != 1 || b.Labels[0] != name {
+			continue
+		}
+		b.SetAttributeRaw("source", hclwrite.TokensForValue(cty.StringVal(source)))
+		b.WriteBody().RemoveAttribute("version")
+		return m.SaveToDisk()
+	}
+	return fmt.Errorf("cannot find module block %s in %s", name, dir)
+}
+
+// rebaseManifest points the vendored module in `modules.json` at its copy, along with its local child modules, so
+// `mapotf transform -r` sees the copy without another `terraform init`.
+func rebaseManifest(manifest *pkg.ModuleManifest, root, key, src, dest, source string) error {
+	for _, m := range manifest.Modules {
+		k := m.Get("Key")
+		if k != key && !strings.HasPrefix(k, key+".") {
+			continue
+		}
+		dir := manifestDir(root, m.Get("Dir"))
+		if !isUnder(dir, src) {
+			continue
+		}
+		rel, err := filepath.Rel(src, dir)
+		if err != nil {
+			return err
+		}
+		newDir, err := filepath.Rel(root, filepath.Join(dest, rel))
+		if err != nil {
+			return err
+		}
+		m["Dir"] = filepath.ToSlash(newDir)
+		if k == key {
+			m["Source"] = source
+			delete(m, "Version")
+		}
+	}
+	return manifest.Save()
+}
+
+// manifestDir resolves a dir in `modules.json`, relative dirs are relative to the root module.
+func manifestDir(root, dir string) string {
+	dir = filepath.FromSlash(dir)
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(root, dir)
+}
+
+func relativeSource(from, to string) (string, error) {
+	rel, err := filepath.Rel(from, to)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel, nil
+}
+
+func isUnder(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,129 @@
+package vendoring
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/mapotf/pkg"
+	filesystem "github.com/Azure/mapotf/pkg/fs"
+	"github.com/prashantv/gostub"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rootMainTf = `module "vnet" {
+  source  = "Azure/vnet/azurerm"
+  version = "1.0.0"
+  name    = "vnet"
+}
+`
+
+const modulesJson = `{"Modules":[
+{"Key":"","Source":"","Dir":"."},
+{"Key":"vnet","Source":"registry.terraform.io/Azure/vnet/azurerm","Version":"1.0.0","Dir":".terraform/modules/vnet"},
+{"Key":"vnet.subnet","Source":"./modules/subnet","Dir":".terraform/modules/vnet/modules/subnet"},
+{"Key":"vnet.nsg","Source":"registry.terraform.io/Azure/nsg/azurerm","Version":"2.0.0","Dir":".terraform/modules/vnet.nsg"}]}`
+
+func fakeFs(files map[string]string) afero.Fs {
+	fs := afero.NewMemMapFs()
+	for n, content := range files {
+		_ = afero.WriteFile(fs, n, []byte(content), 0644)
+	}
+	return fs
+}
+
+func fakeRoot(root string) afero.Fs {
+	return fakeFs(map[string]string{
+		filepath.Join(root, "main.tf"):                                                       rootMainTf,
+		filepath.Join(root, ".terraform", "modules", "modules.json"):                         modulesJson,
+		filepath.Join(root, ".terraform", "modules", "vnet", "main.tf"):                      `resource "fake_resource" vnet {}`,
+		filepath.Join(root, ".terraform", "modules", "vnet", ".git", "HEAD"):                 "ref: refs/heads/main",
+		filepath.Join(root, ".terraform", "modules", "vnet", "modules", "subnet", "main.tf"): `resource "fake_resource" subnet {}`,
+		filepath.Join(root, ".terraform", "modules", "vnet.nsg", "main.tf"):                  `resource "fake_resource" nsg {}`,
+	})
+}
+
+func TestVendor(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, fakeRoot(root))
+	defer stub.Reset()
+	dest := filepath.Join(root, "vendor", "vnet")
+
+	record, err := Vendor(root, "vnet", dest)
+	require.NoError(t, err)
+
+	assert.Equal(t, "vnet", record.Key)
+	assert.Equal(t, "registry.terraform.io/Azure/vnet/azurerm", record.Source)
+	assert.Equal(t, "1.0.0", record.Version)
+	saved, err := ReadRecord(dest)
+	require.NoError(t, err)
+	assert.Equal(t, record.Source, saved.Source)
+	for _, dir := range []string{dest, PristinePath(dest)} {
+		content, err := afero.ReadFile(filesystem.Fs, filepath.Join(dir, "modules", "subnet", "main.tf"))
+		require.NoError(t, err)
+		assert.Equal(t, `resource "fake_resource" subnet {}`, string(content))
+		exists, err := afero.Exists(filesystem.Fs, filepath.Join(dir, ".git"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(root, "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, `module "vnet" {
+  source = "./vendor/vnet"
+  name   = "vnet"
+}
+`, string(content))
+
+	manifest, err := pkg.LoadModuleManifest(root)
+	require.NoError(t, err)
+	vnet := manifest.Module("vnet")
+	assert.Equal(t, "./vendor/vnet", vnet.Get("Source"))
+	assert.Equal(t, "vendor/vnet", vnet.Get("Dir"))
+	assert.NotContains(t, vnet, "Version")
+	assert.Equal(t, "vendor/vnet/modules/subnet", manifest.Module("vnet.subnet").Get("Dir"))
+	assert.Equal(t, ".terraform/modules/vnet.nsg", manifest.Module("vnet.nsg").Get("Dir"))
+}
+
+func TestVendor_NestedModuleShouldBeCalledFromVendoredModule(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, fakeRoot(root))
+	defer stub.Reset()
+
+	_, err := Vendor(root, "vnet.nsg", filepath.Join(root, "vendor", "vnet.nsg"))
+	require.ErrorContains(t, err, "vendor module vnet first")
+
+	_, err = Vendor(root, "vnet", filepath.Join(root, "vendor", "vnet"))
+	require.NoError(t, err)
+	_ = afero.WriteFile(filesystem.Fs, filepath.Join(root, "vendor", "vnet", "nsg.tf"), []byte(`module "nsg" {
+  source  = "Azure/nsg/azurerm"
+  version = "2.0.0"
+}
+`), 0644)
+	_, err = Vendor(root, "vnet.nsg", filepath.Join(root, "vendor", "vnet.nsg"))
+	require.NoError(t, err)
+	content, err := afero.ReadFile(filesystem.Fs, filepath.Join(root, "vendor", "vnet", "nsg.tf"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `source = "../vnet.nsg"`)
+}
+
+func TestVendor_ShouldRefuseLocalModules(t *testing.T) {
+	root := "/cfg"
+	stub := gostub.Stub(&filesystem.Fs, fakeRoot(root))
+	defer stub.Reset()
+
+	_, err := Vendor(root, "vnet.subnet", filepath.Join(root, "vendor", "subnet"))
+	require.ErrorContains(t, err, "is a local module already")
+}
+
+func TestRecord_MptfDirsShouldBeRelativeToVendoredModule(t *testing.T) {
+	stub := gostub.Stub(&filesystem.Fs, fakeFs(map[string]string{
+		"/cfg/mptf/main.mptf.hcl": "",
+	}))
+	defer stub.Reset()
+
+	r := &Record{}
+	r.SetMptfDirs("/cfg/vendor/vnet", []string{"/cfg/mptf", "git::https://github.com/Azure/mapotf.git//example"})
+	assert.Equal(t, []string{"../../mptf", "git::https://github.com/Azure/mapotf.git//example"}, r.MptfDirs)
+	assert.Equal(t, []string{"/cfg/mptf", "git::https://github.com/Azure/mapotf.git//example"}, r.ResolveMptfDirs("/cfg/vendor/vnet"))
+}